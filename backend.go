@@ -2,7 +2,13 @@ package saltyhash
 
 import (
 	"context"
+	"crypto/cipher"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -14,6 +20,43 @@ type backend struct {
 	// predefined number of locks when the backend is created, and will be
 	// indexed based on salted role names.
 	roleLocks []*locksutil.LockEntry
+
+	// Locks to make changes to hash_stream sessions, indexed by session ID.
+	hashStreamLocks []*locksutil.LockEntry
+
+	// Mount keys used to wrap salts on import and export, lazily loaded or
+	// generated on first use.
+	mountKeys     *mountKeys
+	mountKeysLock sync.Mutex
+
+	// Cached mount config, see getConfig.
+	config     *configEntry
+	configLock sync.RWMutex
+
+	// Usage of roles not flushed to storage yet, see flushUsage. The storage
+	// of the mount is kept to flush it on unload, see clean.
+	storage        logical.Storage
+	usage          map[string]*roleUsage
+	usageLock      sync.Mutex
+	usageFlushLock sync.Mutex
+
+	// Token buckets of roles with rate limits, see allowHash.
+	limiters     map[string]*roleLimiter
+	limitersLock sync.Mutex
+
+	// now returns the current time of the rate limiters and of the expiry
+	// of hash_stream sessions.
+	now func() time.Time
+
+	// Cipher of the mount key encrypting salts in storage, see getSaltCipher.
+	saltCipher     cipher.AEAD
+	saltCipherLock sync.Mutex
+
+	// Key sources roles can take their salts from by name, and the key
+	// material fetched from them, see resolveKeySource.
+	keySources      map[string]keySourceFactory
+	keyMaterial     map[string]*cachedKeyMaterial
+	keyMaterialLock sync.Mutex
 }
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
@@ -24,20 +67,115 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 	return b, nil
 }
 
-func Backend(_ context.Context, _ *logical.BackendConfig) *backend {
+func Backend(_ context.Context, conf *logical.BackendConfig) *backend {
 	b := &backend{
-		roleLocks: locksutil.CreateLocks(),
+		roleLocks:       locksutil.CreateLocks(),
+		hashStreamLocks: locksutil.CreateLocks(),
+		storage:         conf.StorageView,
+		usage:           make(map[string]*roleUsage),
+		limiters:        make(map[string]*roleLimiter),
+		now:             wallClock,
+		keySources: map[string]keySourceFactory{
+			"transit": newTransitKeySource,
+		},
+		keyMaterial: make(map[string]*cachedKeyMaterial),
 	}
 
 	b.Backend = &framework.Backend{
 		BackendType: logical.TypeLogical,
+		PathsSpecial: &logical.Paths{
+			// Entries ending with a slash are prefixes, the others
+			// exact keys, so config/storage_version isn't wrapped.
+			SealWrapStorage: []string{
+				"roles/",
+				hashStreamStoragePrefix,
+				configStoragePath,
+				mountKeysStoragePath,
+				saltKeyStoragePath,
+			},
+		},
 		Paths: []*framework.Path{
 				b.pathHash(),
 				b.pathHashBatch(),
+				b.pathHashStreamStart(),
+				b.pathHashStreamChunk(),
+				b.pathHashStreamFinish(),
+				b.pathRehash(),
 				b.pathListRoles(),
 				b.pathRoles(),
+				b.pathRoleClone(),
+				b.pathRotate(),
+				b.pathExportSalt(),
+				b.pathUsage(),
+				b.pathRoleImport(),
+				b.pathListRoleTemplates(),
+				b.pathRoleTemplates(),
+				b.pathImport(),
+				b.pathExport(),
+				b.pathWrappingKey(),
+				b.pathConfig(),
 		},
+		InitializeFunc: b.initialize,
+		PeriodicFunc:   b.periodicFunc,
+		Invalidate:     b.invalidate,
+		Clean:          b.clean,
 	}
 
 	return b
-}
\ No newline at end of file
+}
+
+func (b *backend) invalidate(_ context.Context, key string) {
+	switch key {
+	case configStoragePath:
+		b.invalidateConfig()
+		b.resetKeyMaterial()
+	}
+}
+
+// storageWritable reports whether this node can write the storage of the
+// mount. Replicated storage is written on the primary only, performance
+// secondaries and standbys get changes through replication.
+func (b *backend) storageWritable() bool {
+	replicationState := b.System().ReplicationState()
+	return !replicationState.HasState(consts.ReplicationPerformanceStandby) &&
+		(!replicationState.HasState(consts.ReplicationPerformanceSecondary) || b.System().LocalMount())
+}
+
+func (b *backend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	if !b.storageWritable() {
+		return nil
+	}
+
+	return b.upgradeStorage(ctx, req.Storage)
+}
+
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if !b.storageWritable() {
+		return nil
+	}
+
+	var retErr error
+	if err := b.autoRotateRoles(ctx, req.Storage); err != nil {
+		retErr = multierror.Append(retErr, err)
+	}
+	if err := b.flushUsage(ctx, req.Storage); err != nil {
+		retErr = multierror.Append(retErr, err)
+	}
+	if err := b.cleanHashStreams(ctx, req.Storage); err != nil {
+		retErr = multierror.Append(retErr, err)
+	}
+
+	return retErr
+}
+
+// clean flushes the pending usage when the mount is unloaded, so usage
+// recorded since the last periodic flush isn't lost.
+func (b *backend) clean(ctx context.Context) {
+	if b.storage == nil || !b.storageWritable() {
+		return
+	}
+
+	if err := b.flushUsage(ctx, b.storage); err != nil {
+		b.Logger().Error("failed to flush usage on unload", "error", err)
+	}
+}
@@ -29,6 +29,25 @@ func createBackendWithStorage(t testing.TB) (*backend, logical.Storage) {
 	return b, config.StorageView
 }
 
+// handleRequest handles the request against the storage, failing the test
+// unless it fails as expected, with an error or an error response.
+func handleRequest(t testing.TB, b *backend, storage logical.Storage, req *logical.Request, errExpected bool) *logical.Response {
+	t.Helper()
+
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if errExpected {
+		if err == nil && !resp.IsError() {
+			t.Fatalf("bad: got no error response when error expected")
+		}
+		return resp
+	}
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+	}
+	return resp
+}
+
 func TestBackend(t *testing.T) {
 	logicaltest.Test(t, logicaltest.TestCase{
 		LogicalFactory: Factory,
@@ -101,6 +120,9 @@ func testAccStepReadRole(t *testing.T, roleName string, salt string) logicaltest
 			if len(resp.Data) == 0 {
 				return fmt.Errorf("no data returned")
 			}
+			if _, ok := resp.Data["salt"]; ok {
+				return fmt.Errorf("salt returned by read")
+			}
 
 			var d struct {
 				Role []string `mapstructure:"test"`
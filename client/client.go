@@ -0,0 +1,486 @@
+// Package client is a typed client of the API of a saltyhash mount.
+//
+// It wraps a Vault API client: inputs are given as raw bytes and base64
+// encoded by the client, sums are returned hex-encoded as computed by the
+// plugin, and batches are split into requests no larger than the max batch
+// size of the mount.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+// DefaultBatchSize is the number of inputs per batch request when the mount
+// doesn't limit the batch size, or its config can't be read.
+const DefaultBatchSize = 1000
+
+// DefaultStreamChunkSize is the number of input bytes per request of
+// HashStream when no chunk size is given.
+const DefaultStreamChunkSize = 1 << 20
+
+// Client calls the API of a saltyhash mount. It is safe for concurrent use.
+type Client struct {
+	vault *api.Client
+	mount string
+
+	batchSizeLock sync.Mutex
+	batchSize     int
+}
+
+// New returns a client of the saltyhash mount at the given path, calling
+// Vault with the given client and its token.
+func New(vault *api.Client, mount string) *Client {
+	return &Client{
+		vault: vault,
+		mount: strings.Trim(mount, "/"),
+	}
+}
+
+// SetBatchSize sets the number of inputs per batch request. If zero, the
+// default, it is discovered from the max_batch_size of the mount config on
+// the first batch.
+func (c *Client) SetBatchSize(size int) {
+	c.batchSizeLock.Lock()
+	defer c.batchSizeLock.Unlock()
+
+	c.batchSize = size
+}
+
+// BatchSize returns the number of inputs per batch request, discovering it
+// from the mount config if unset. Tokens not allowed to read the config get
+// DefaultBatchSize.
+func (c *Client) BatchSize(ctx context.Context) int {
+	c.batchSizeLock.Lock()
+	defer c.batchSizeLock.Unlock()
+
+	if c.batchSize > 0 {
+		return c.batchSize
+	}
+
+	c.batchSize = DefaultBatchSize
+	var config struct {
+		MaxBatchSize int `json:"max_batch_size"`
+	}
+	if err := c.read(ctx, "config", &config); err == nil && config.MaxBatchSize > 0 {
+		c.batchSize = config.MaxBatchSize
+	}
+
+	return c.batchSize
+}
+
+// Sum is a sum computed by the plugin, along with the salt version it was
+// computed with.
+type Sum struct {
+	Sum         string `json:"sum"`
+	SaltVersion int    `json:"salt_version"`
+}
+
+// Hash returns the sum of the input under the latest salt of the role.
+func (c *Client) Hash(ctx context.Context, role, algorithm string, input []byte) (*Sum, error) {
+	var sum Sum
+	err := c.write(ctx, path.Join("hash", role, algorithm), map[string]interface{}{
+		"input": hasher.EncodeInput(input),
+	}, &sum)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sum, nil
+}
+
+// HashBatch returns the sums of the inputs in order, in as many requests as
+// the batch size requires. Every sum carries its own salt version, as the
+// role may be rotated between requests.
+func (c *Client) HashBatch(ctx context.Context, role, algorithm string, inputs [][]byte) ([]Sum, error) {
+	batchSize := c.BatchSize(ctx)
+
+	sums := make([]Sum, 0, len(inputs))
+	for start := 0; start < len(inputs); start += batchSize {
+		chunk := inputs[start:min(start+batchSize, len(inputs))]
+
+		encoded := make([]string, len(chunk))
+		for i, input := range chunk {
+			encoded[i] = hasher.EncodeInput(input)
+		}
+
+		var resp struct {
+			Sums        []string `json:"sums"`
+			SaltVersion int      `json:"salt_version"`
+		}
+		err := c.write(ctx, path.Join("hash_batch", role, algorithm), map[string]interface{}{
+			"input": encoded,
+		}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Sums) != len(chunk) {
+			return nil, fmt.Errorf("expected %d sums, got %d", len(chunk), len(resp.Sums))
+		}
+
+		for _, sum := range resp.Sums {
+			sums = append(sums, Sum{Sum: sum, SaltVersion: resp.SaltVersion})
+		}
+	}
+
+	return sums, nil
+}
+
+// HashStream returns the sum of the input read from r, sent in chunks of
+// chunkSize bytes, or DefaultStreamChunkSize if zero, over a hash_stream
+// session. The algorithm must support streaming. The session is aborted if
+// the input can't be read or sent.
+func (c *Client) HashStream(ctx context.Context, role, algorithm string, r io.Reader, chunkSize int) (*Sum, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultStreamChunkSize
+	}
+
+	var session struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := c.write(ctx, path.Join("hash_stream", role, algorithm), nil, &session); err != nil {
+		return nil, err
+	}
+	sessionPath := path.Join("hash_stream", role, algorithm, session.SessionID)
+
+	buf := make([]byte, chunkSize)
+	for offset := 0; ; {
+		n, err := io.ReadFull(r, buf)
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			// The last chunk is sent along with finish.
+			data := map[string]interface{}{"offset": offset}
+			if n > 0 {
+				data["input"] = hasher.EncodeInput(buf[:n])
+			}
+			var sum Sum
+			if err := c.write(ctx, path.Join(sessionPath, "finish"), data, &sum); err != nil {
+				c.abortStream(ctx, sessionPath)
+				return nil, err
+			}
+			return &sum, nil
+		default:
+			c.abortStream(ctx, sessionPath)
+			return nil, err
+		}
+
+		err = c.write(ctx, sessionPath, map[string]interface{}{
+			"input":  hasher.EncodeInput(buf[:n]),
+			"offset": offset,
+		}, nil)
+		if err != nil {
+			c.abortStream(ctx, sessionPath)
+			return nil, err
+		}
+		offset += n
+	}
+}
+
+// abortStream deletes the session on a best-effort basis, it expires anyway.
+func (c *Client) abortStream(ctx context.Context, sessionPath string) {
+	_, _ = c.request(ctx, http.MethodDelete, sessionPath, nil)
+}
+
+// RehashInput is a sum to migrate to the latest salt version of a role, and
+// the input and settings it was computed with.
+type RehashInput struct {
+	Input       []byte
+	Sum         string
+	SaltVersion int
+	Algorithm   string
+
+	// NewAlgorithm is the algorithm of the new sum, Algorithm if empty.
+	NewAlgorithm string
+}
+
+func (in RehashInput) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"input":        hasher.EncodeInput(in.Input),
+		"sum":          in.Sum,
+		"salt_version": in.SaltVersion,
+		"algorithm":    in.Algorithm,
+	}
+	if in.NewAlgorithm != "" {
+		data["new_algorithm"] = in.NewAlgorithm
+	}
+
+	return data
+}
+
+// RehashResult is a migrated sum, or the error migrating it in a batch.
+// Match reports whether the sum matched the input, nil if the item couldn't
+// be verified at all.
+type RehashResult struct {
+	Sum         string `json:"sum"`
+	SaltVersion int    `json:"salt_version"`
+	Algorithm   string `json:"algorithm"`
+	Match       *bool  `json:"match"`
+	Error       string `json:"error"`
+}
+
+// Rehash verifies the sum against the input and returns the sum under the
+// latest salt version. A mismatch fails the request.
+func (c *Client) Rehash(ctx context.Context, role string, in RehashInput) (*RehashResult, error) {
+	var result RehashResult
+	if err := c.write(ctx, path.Join("rehash", role), in.data(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// RehashBatch migrates the sums in order, in as many requests as the batch
+// size requires. Mismatches and invalid items are reported in the Error of
+// their result rather than failing the batch.
+func (c *Client) RehashBatch(ctx context.Context, role string, inputs []RehashInput) ([]RehashResult, error) {
+	batchSize := c.BatchSize(ctx)
+
+	results := make([]RehashResult, 0, len(inputs))
+	for start := 0; start < len(inputs); start += batchSize {
+		chunk := inputs[start:min(start+batchSize, len(inputs))]
+
+		batch := make([]map[string]interface{}, len(chunk))
+		for i, in := range chunk {
+			batch[i] = in.data()
+		}
+
+		var resp struct {
+			BatchResults []RehashResult `json:"batch_results"`
+		}
+		err := c.write(ctx, path.Join("rehash", role), map[string]interface{}{
+			"batch_input": batch,
+		}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.BatchResults) != len(chunk) {
+			return nil, fmt.Errorf("expected %d results, got %d", len(chunk), len(resp.BatchResults))
+		}
+
+		results = append(results, resp.BatchResults...)
+	}
+
+	return results, nil
+}
+
+// Verify reports whether the sum was computed from the input with the given
+// algorithm and salt version of the role. The comparison is done by the
+// plugin in constant time.
+func (c *Client) Verify(ctx context.Context, role, algorithm string, saltVersion int, input []byte, sum string) (bool, error) {
+	results, err := c.RehashBatch(ctx, role, []RehashInput{{
+		Input:       input,
+		Sum:         sum,
+		SaltVersion: saltVersion,
+		Algorithm:   algorithm,
+	}})
+	if err != nil {
+		return false, err
+	}
+
+	result := results[0]
+	if result.Match == nil {
+		return false, fmt.Errorf("unable to verify sum: %s", result.Error)
+	}
+
+	return *result.Match, nil
+}
+
+// Role is the configuration of a role. Salt is only sent by WriteRole to
+// create a role with its own salt, ReadRole never returns it: salts are only
+// handed out response-wrapped by the export_salt endpoint.
+type Role struct {
+	Salt       string            `json:"salt,omitempty"`
+	Mode       string            `json:"mode,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	KeySource  string            `json:"key_source,omitempty"`
+	KeyName    string            `json:"key_name,omitempty"`
+	Exportable bool              `json:"exportable,omitempty"`
+
+	AutoRotatePeriod time.Duration `json:"-"`
+
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	RequestsBurst     int     `json:"requests_burst,omitempty"`
+	ItemsPerSecond    float64 `json:"items_per_second,omitempty"`
+	ItemsBurst        int     `json:"items_burst,omitempty"`
+
+	// Read-only state of the role.
+	SaltVersion      int       `json:"salt_version,omitempty"`
+	LastRotationTime time.Time `json:"last_rotation_time,omitempty"`
+}
+
+// roleData is the API form of a role, with durations in seconds.
+type roleData struct {
+	*Role
+	AutoRotatePeriod int64 `json:"auto_rotate_period,omitempty"`
+}
+
+// ReadRole returns the configuration of the role.
+func (c *Client) ReadRole(ctx context.Context, name string) (*Role, error) {
+	role := &Role{}
+	data := roleData{Role: role}
+	if err := c.read(ctx, path.Join("roles", name), &data); err != nil {
+		return nil, err
+	}
+	role.AutoRotatePeriod = time.Duration(data.AutoRotatePeriod) * time.Second
+
+	return role, nil
+}
+
+// WriteRole creates the role or updates its settings. Only the non-zero
+// settings are sent, the others keep their current value, or their default
+// on creation. SaltVersion and LastRotationTime are ignored.
+func (c *Client) WriteRole(ctx context.Context, name string, role *Role) error {
+	settings := *role
+	settings.SaltVersion = 0
+	settings.LastRotationTime = time.Time{}
+
+	data, err := toMap(roleData{
+		Role:             &settings,
+		AutoRotatePeriod: int64(role.AutoRotatePeriod.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	// The zero time isn't omitted by encoding/json.
+	delete(data, "last_rotation_time")
+
+	return c.write(ctx, path.Join("roles", name), data, nil)
+}
+
+// CreateRoleFromTemplate creates the role with the settings of the template
+// and a freshly generated salt.
+func (c *Client) CreateRoleFromTemplate(ctx context.Context, name, template string) error {
+	return c.write(ctx, path.Join("roles", name), map[string]interface{}{
+		"template": template,
+	}, nil)
+}
+
+// DeleteRole deletes the role.
+func (c *Client) DeleteRole(ctx context.Context, name string) error {
+	_, err := c.request(ctx, http.MethodDelete, path.Join("roles", name), nil)
+	return err
+}
+
+// ListRoles returns the names of the roles.
+func (c *Client) ListRoles(ctx context.Context) ([]string, error) {
+	var list struct {
+		Keys []string `json:"keys"`
+	}
+	secret, err := c.request(ctx, "LIST", "roles", nil)
+	if respErr, ok := err.(*api.ResponseError); ok && respErr.StatusCode == http.StatusNotFound {
+		// Vault responds to empty lists with a 404.
+		return nil, nil
+	}
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	if err := fromMap(secret.Data, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Keys, nil
+}
+
+// RotateRole rotates the salt of the role and returns the new salt version.
+func (c *Client) RotateRole(ctx context.Context, name string) (int, error) {
+	var resp struct {
+		SaltVersion int `json:"salt_version"`
+	}
+	if err := c.write(ctx, path.Join("roles", name, "rotate"), nil, &resp); err != nil {
+		return 0, err
+	}
+
+	return resp.SaltVersion, nil
+}
+
+func (c *Client) read(ctx context.Context, p string, out interface{}) error {
+	secret, err := c.request(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("no data found at %s", p)
+	}
+
+	return fromMap(secret.Data, out)
+}
+
+func (c *Client) write(ctx context.Context, p string, data map[string]interface{}, out interface{}) error {
+	secret, err := c.request(ctx, http.MethodPut, p, data)
+	if err != nil || out == nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("empty response from %s", p)
+	}
+
+	return fromMap(secret.Data, out)
+}
+
+// request calls the given path of the mount. The returned secret is nil if
+// the response has no body.
+func (c *Client) request(ctx context.Context, method, p string, data map[string]interface{}) (*api.Secret, error) {
+	req := c.vault.NewRequest(method, "/v1/"+path.Join(c.mount, p))
+	if data != nil {
+		if err := req.SetJSONBody(data); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.vault.RawRequestWithContext(ctx, req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// fromMap decodes the data of a response into out through its JSON form.
+func fromMap(data map[string]interface{}, out interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, out)
+}
+
+// toMap encodes the JSON form of in as request data.
+func toMap(in interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,336 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/internal/devserver"
+)
+
+const (
+	testMount = "saltyhash"
+	testSalt  = "c2VjcmV0c2FsdA=="
+)
+
+// testServer is an in-process stand-in of Vault, serving the HTTP API of a
+// saltyhash mount through the dev server. It counts the requests by path.
+type testServer struct {
+	sync.Mutex
+	requests map[string]int
+}
+
+func newTestClient(t *testing.T) (*Client, *testServer) {
+	handler, err := devserver.New(context.Background(), testMount, "root", &logical.InmemStorage{}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{requests: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.Lock()
+		ts.requests[strings.TrimPrefix(r.URL.Path, "/v1/"+testMount+"/")]++
+		ts.Unlock()
+
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	vault, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.SetToken("root")
+
+	return New(vault, testMount), ts
+}
+
+func (ts *testServer) count(path string) int {
+	ts.Lock()
+	defer ts.Unlock()
+
+	return ts.requests[path]
+}
+
+// sessionRequests returns the number of hash_stream requests under a
+// session.
+func (ts *testServer) sessionRequests() int {
+	ts.Lock()
+	defer ts.Unlock()
+
+	requests := 0
+	for p, n := range ts.requests {
+		if strings.HasPrefix(p, "hash_stream/") && strings.Count(p, "/") >= 3 {
+			requests += n
+		}
+	}
+
+	return requests
+}
+
+func TestClient_Roles(t *testing.T) {
+	c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	roles, err := c.ListRoles(ctx)
+	if err != nil || len(roles) != 0 {
+		t.Fatalf("bad empty role list: %v, err: %v", roles, err)
+	}
+
+	err = c.WriteRole(ctx, "test", &Role{
+		Salt:             testSalt,
+		Mode:             hasher.ModeAppend,
+		Metadata:         map[string]string{"team": "billing"},
+		AutoRotatePeriod: 48 * time.Hour,
+		ItemsPerSecond:   100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role, err := c.ReadRole(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Salt != "" || role.Mode != hasher.ModeAppend || role.Metadata["team"] != "billing" ||
+		role.AutoRotatePeriod != 48*time.Hour || role.ItemsPerSecond != 100 || role.SaltVersion != 1 ||
+		role.LastRotationTime.IsZero() {
+		t.Fatalf("bad role: %#v", role)
+	}
+
+	// Test updates only change the given settings
+	if err := c.WriteRole(ctx, "test", &Role{Exportable: true}); err != nil {
+		t.Fatal(err)
+	}
+	role, err = c.ReadRole(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !role.Exportable || role.Mode != hasher.ModeAppend || role.AutoRotatePeriod != 48*time.Hour {
+		t.Fatalf("bad updated role: %#v", role)
+	}
+
+	version, err := c.RotateRole(ctx, "test")
+	if err != nil || version != 2 {
+		t.Fatalf("bad rotation: version %d, err: %v", version, err)
+	}
+
+	roles, err = c.ListRoles(ctx)
+	if err != nil || len(roles) != 1 || roles[0] != "test" {
+		t.Fatalf("bad role list: %v, err: %v", roles, err)
+	}
+
+	if err := c.DeleteRole(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadRole(ctx, "test"); err == nil {
+		t.Fatal("expected error reading a deleted role")
+	}
+}
+
+func TestClient_Hash(t *testing.T) {
+	c, ts := newTestClient(t)
+	ctx := context.Background()
+
+	if err := c.WriteRole(ctx, "test", &Role{Salt: testSalt, Mode: hasher.ModeAppend}); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := hasher.NewFromBase64(hasher.SHA2256, testSalt, hasher.ModeAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := c.Hash(ctx, "test", hasher.SHA2256, []byte("secretdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Sum != "675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98" || sum.SaltVersion != 1 {
+		t.Fatalf("bad sum: %#v", sum)
+	}
+
+	if _, err := c.Hash(ctx, "missing", hasher.SHA2256, []byte("secretdata")); err == nil {
+		t.Fatal("expected error hashing with a missing role")
+	}
+
+	// Test batches are chunked by the max batch size of the mount
+	if _, err := c.vault.Logical().Write(testMount+"/config", map[string]interface{}{"max_batch_size": 10}); err != nil {
+		t.Fatal(err)
+	}
+	if size := c.BatchSize(ctx); size != 10 {
+		t.Fatalf("bad discovered batch size: %d", size)
+	}
+
+	inputs := make([][]byte, 25)
+	for i := range inputs {
+		inputs[i] = []byte(fmt.Sprintf("secret-%d", i))
+	}
+	sums, err := c.HashBatch(ctx, "test", hasher.SHA2256, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != len(inputs) {
+		t.Fatalf("expected %d sums, got %d", len(inputs), len(sums))
+	}
+	for i, sum := range sums {
+		if sum.Sum != h.SumHex(inputs[i]) || sum.SaltVersion != 1 {
+			t.Fatalf("bad sum %d: %#v", i, sum)
+		}
+	}
+	if requests := ts.count("hash_batch/test/sha2-256"); requests != 3 {
+		t.Fatalf("expected 3 batch requests, got %d", requests)
+	}
+
+	// Test a batch size larger than the mount's fails
+	c.SetBatchSize(20)
+	if _, err := c.HashBatch(ctx, "test", hasher.SHA2256, inputs); err == nil {
+		t.Fatal("expected error with batches larger than the max batch size")
+	}
+}
+
+// failingReader returns the input, then fails.
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("read failed")
+	}
+
+	return n, err
+}
+
+func TestClient_HashStream(t *testing.T) {
+	c, ts := newTestClient(t)
+	ctx := context.Background()
+
+	if err := c.WriteRole(ctx, "test", &Role{Salt: testSalt, Mode: hasher.ModePrepend}); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := hasher.NewFromBase64(hasher.SHA2512, testSalt, hasher.ModePrepend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test inputs are sent in chunks, the last one along with finish, even
+	// when the input is a multiple of the chunk size
+	for _, size := range []int{0, 1, 100, 250} {
+		input := bytes.Repeat([]byte("x"), size)
+		before := ts.sessionRequests()
+
+		sum, err := c.HashStream(ctx, "test", hasher.SHA2512, bytes.NewReader(input), 100)
+		if size == 0 {
+			if err == nil {
+				t.Fatal("expected error hashing an empty input")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum.Sum != h.SumHex(input) || sum.SaltVersion != 1 {
+			t.Fatalf("%d: bad sum: %#v", size, sum)
+		}
+		if requests := ts.sessionRequests() - before; requests != 1+size/100 {
+			t.Fatalf("%d: expected %d session requests, got %d", size, 1+size/100, requests)
+		}
+	}
+
+	// Test sessions are aborted if the input can't be read
+	before := ts.sessionRequests()
+	_, err = c.HashStream(ctx, "test", hasher.SHA2512, &failingReader{r: bytes.NewReader(make([]byte, 150))}, 100)
+	if err == nil || err.Error() != "read failed" {
+		t.Fatalf("expected read error, got: %v", err)
+	}
+	if requests := ts.sessionRequests() - before; requests != 2 {
+		t.Fatalf("expected a chunk and an abort, got %d session requests", requests)
+	}
+
+	if _, err := c.HashStream(ctx, "test", hasher.SHA3512, bytes.NewReader([]byte("secretdata")), 0); err == nil {
+		t.Fatal("expected error with an algorithm without streaming")
+	}
+}
+
+func TestClient_Rehash(t *testing.T) {
+	c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	if err := c.WriteRole(ctx, "test", &Role{Salt: testSalt, Mode: hasher.ModePrepend}); err != nil {
+		t.Fatal(err)
+	}
+
+	input := []byte("secretdata")
+	sum, err := c.Hash(ctx, "test", hasher.SHA1, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test verification
+	ok, err := c.Verify(ctx, "test", hasher.SHA1, 1, input, sum.Sum)
+	if err != nil || !ok {
+		t.Fatalf("sum not verified: %v", err)
+	}
+	ok, err = c.Verify(ctx, "test", hasher.SHA1, 1, []byte("other"), sum.Sum)
+	if err != nil || ok {
+		t.Fatalf("mismatched sum verified: %v", err)
+	}
+	if _, err := c.Verify(ctx, "test", hasher.SHA1, 7, input, sum.Sum); err == nil {
+		t.Fatal("expected error verifying against a missing salt version")
+	}
+
+	if _, err := c.RotateRole(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := c.Hash(ctx, "test", hasher.SHA3256, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test migration to the latest salt version and another algorithm
+	result, err := c.Rehash(ctx, "test", RehashInput{
+		Input:        input,
+		Sum:          sum.Sum,
+		SaltVersion:  1,
+		Algorithm:    hasher.SHA1,
+		NewAlgorithm: hasher.SHA3256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Sum != latest.Sum || result.SaltVersion != 2 || result.Algorithm != hasher.SHA3256 {
+		t.Fatalf("bad rehash: %#v", result)
+	}
+
+	if _, err := c.Rehash(ctx, "test", RehashInput{Input: []byte("other"), Sum: sum.Sum, SaltVersion: 1, Algorithm: hasher.SHA1}); err == nil {
+		t.Fatal("expected error rehashing a mismatched sum")
+	}
+
+	// Test batches report mismatches per item
+	c.SetBatchSize(1)
+	results, err := c.RehashBatch(ctx, "test", []RehashInput{
+		{Input: input, Sum: sum.Sum, SaltVersion: 1, Algorithm: hasher.SHA1},
+		{Input: []byte("other"), Sum: sum.Sum, SaltVersion: 1, Algorithm: hasher.SHA1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Error != "" || results[0].SaltVersion != 2 || results[0].Match == nil || !*results[0].Match ||
+		results[1].Error == "" || results[1].Match == nil || *results[1].Match {
+		t.Fatalf("bad batch results: %#v", results)
+	}
+}
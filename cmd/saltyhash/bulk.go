@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/client"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+const bulkUsage = `Usage: saltyhash bulk [options]
+
+Pseudonymizes a CSV or NDJSON file through the hash_batch endpoint of a
+mount, replacing the values of the given columns or fields with their sums.
+Vault is reached through the VAULT_ADDR and VAULT_TOKEN environment variables
+like the Vault CLI.
+
+Batches are hashed concurrently and written in order. Progress is
+checkpointed after every written batch, so an interrupted run started again
+with the same options resumes where it stopped. Failed requests are retried
+with exponential backoff if transient: network errors, rate limiting and
+server errors. All sums of a run are of the same salt version, the run fails
+if the role is rotated meanwhile.
+
+Options:
+`
+
+// bulkOptions are the options of the bulk command.
+type bulkOptions struct {
+	mount       string
+	role        string
+	algorithm   string
+	format      string
+	fields      []string
+	input       string
+	output      string
+	checkpoint  string
+	batchSize   int
+	concurrency int
+	retries     int
+}
+
+// bulkBackoff is the delay before the first retry of a batch, doubled on
+// every retry up to bulkMaxBackoff.
+var (
+	bulkBackoff    = 500 * time.Millisecond
+	bulkMaxBackoff = 30 * time.Second
+)
+
+func parseBulkFlags(args []string, stderr io.Writer) (*bulkOptions, error) {
+	opts := &bulkOptions{}
+	var fields string
+
+	flags := flag.NewFlagSet("bulk", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, bulkUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.mount, "mount", "saltyhash", "Path of the saltyhash mount")
+	flags.StringVar(&opts.role, "role", "", "Role to hash with")
+	flags.StringVar(&opts.algorithm, "algorithm", hasher.SHA2256, "Algorithm, one of "+strings.Join(hasher.Algorithms(), ", "))
+	flags.StringVar(&opts.format, "format", "csv", "Record format, one of csv, ndjson")
+	flags.StringVar(&fields, "fields", "", "Comma-separated columns or fields to hash")
+	flags.StringVar(&opts.input, "input", "", "Input file")
+	flags.StringVar(&opts.output, "output", "", "Output file")
+	flags.StringVar(&opts.checkpoint, "checkpoint", "", "Checkpoint file, the output file with a .checkpoint suffix if unset")
+	flags.IntVar(&opts.batchSize, "batch-size", 0, "Inputs per request, the max batch size of the mount if unset")
+	flags.IntVar(&opts.concurrency, "concurrency", 4, "Concurrent requests")
+	flags.IntVar(&opts.retries, "retries", 5, "Retries of transient failures per batch")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case opts.role == "":
+		return nil, errors.New("missing -role")
+	case opts.input == "" || opts.output == "":
+		return nil, errors.New("missing -input or -output")
+	case opts.input == opts.output:
+		return nil, errors.New("-input and -output must be different files")
+	case fields == "":
+		return nil, errors.New("missing -fields")
+	case opts.format != "csv" && opts.format != "ndjson":
+		return nil, fmt.Errorf("unsupported format %s", opts.format)
+	case !hasher.IsSupportedAlgorithm(opts.algorithm):
+		return nil, fmt.Errorf("unsupported algorithm %s", opts.algorithm)
+	case opts.batchSize < 0 || opts.concurrency < 1 || opts.retries < 0:
+		return nil, errors.New("-batch-size and -retries must be non-negative, -concurrency positive")
+	}
+	opts.fields = strings.Split(fields, ",")
+	if opts.checkpoint == "" {
+		opts.checkpoint = opts.output + ".checkpoint"
+	}
+
+	return opts, nil
+}
+
+func runBulk(args []string, _ io.Reader, _, stderr io.Writer) int {
+	opts, err := parseBulkFlags(args, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		fmt.Fprintln(stderr, config.Error)
+		return 2
+	}
+	// Retries are left to the command, which also retries rate limiting.
+	config.MaxRetries = 0
+	vault, err := api.NewClient(config)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := bulkHash(ctx, client.New(vault, opts.mount), opts, stderr); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// bulkCheckpoint is the progress of a bulk run: the number of records
+// written to the output and the size of the output once written.
+type bulkCheckpoint struct {
+	Input        string   `json:"input"`
+	Role         string   `json:"role"`
+	Algorithm    string   `json:"algorithm"`
+	Fields       []string `json:"fields"`
+	SaltVersion  int      `json:"salt_version"`
+	Records      int      `json:"records"`
+	OutputOffset int64    `json:"output_offset"`
+}
+
+func (c *bulkCheckpoint) matches(opts *bulkOptions) bool {
+	return c.Input == opts.input && c.Role == opts.role && c.Algorithm == opts.algorithm &&
+		strings.Join(c.Fields, ",") == strings.Join(opts.fields, ",")
+}
+
+func readBulkCheckpoint(path string) (*bulkCheckpoint, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &bulkCheckpoint{}
+	if err := json.Unmarshal(raw, checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+
+	return checkpoint, nil
+}
+
+// writeBulkCheckpoint replaces the checkpoint file, through a rename so it
+// is never left half-written.
+func writeBulkCheckpoint(path string, checkpoint *bulkCheckpoint) error {
+	raw, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", raw, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// bulkBatch is a batch of records, the values of their fields and the
+// inputs to hash, the non-empty values, in order.
+type bulkBatch struct {
+	index   int
+	records []interface{}
+	values  [][]string
+	inputs  [][]byte
+	sums    []client.Sum
+	err     error
+}
+
+// bulkHash hashes the fields of the records of the input to the output, in
+// batches hashed concurrently and written in order.
+func bulkHash(ctx context.Context, c *client.Client, opts *bulkOptions, stderr io.Writer) error {
+	checkpoint, err := readBulkCheckpoint(opts.checkpoint)
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil && !checkpoint.matches(opts) {
+		return fmt.Errorf("checkpoint %s is of another run, remove it to start over", opts.checkpoint)
+	}
+	resuming := checkpoint != nil
+	if !resuming {
+		checkpoint = &bulkCheckpoint{
+			Input:     opts.input,
+			Role:      opts.role,
+			Algorithm: opts.algorithm,
+			Fields:    opts.fields,
+		}
+	}
+
+	batchSize := opts.batchSize
+	if batchSize == 0 {
+		batchSize = c.BatchSize(ctx)
+	}
+	c.SetBatchSize(batchSize)
+	// Batches are cut on record boundaries, at most every field of every
+	// record is hashed.
+	recordsPerBatch := batchSize / len(opts.fields)
+	if recordsPerBatch == 0 {
+		return fmt.Errorf("batch size %d is smaller than the %d fields of a record", batchSize, len(opts.fields))
+	}
+
+	in, err := os.Open(opts.input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(opts.output, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := out.Truncate(checkpoint.OutputOffset); err != nil {
+		return err
+	}
+	if _, err := out.Seek(checkpoint.OutputOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader, writer, err := newRecordCodec(opts, in, out, !resuming)
+	if err != nil {
+		return err
+	}
+	if err := writer.flush(); err != nil {
+		return err
+	}
+
+	// Skip the records already written
+	for i := 0; i < checkpoint.Records; i++ {
+		if _, _, err := reader.read(); err != nil {
+			return fmt.Errorf("unable to skip the %d records already written: %w", checkpoint.Records, err)
+		}
+	}
+	if resuming {
+		fmt.Fprintf(stderr, "resuming after %d records\n", checkpoint.Records)
+	}
+
+	// Workers report retries concurrently.
+	stderr = &lockedWriter{w: stderr}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	batches := make(chan *bulkBatch, opts.concurrency)
+	results := make(chan *bulkBatch, opts.concurrency)
+
+	var readErr error
+	go func() {
+		defer close(batches)
+		readErr = readBatches(ctx, reader, recordsPerBatch, batches)
+	}()
+
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				batch.sums, batch.err = hashBatchWithRetries(ctx, c, opts, batch.inputs, stderr)
+				select {
+				case results <- batch:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Batches are written in order of reading, holding back those hashed
+	// before their predecessors.
+	pending := make(map[int]*bulkBatch)
+	next := 0
+	for batch := range results {
+		if batch.err != nil {
+			return batch.err
+		}
+		pending[batch.index] = batch
+
+		for batch := pending[next]; batch != nil; batch = pending[next] {
+			delete(pending, next)
+			next++
+
+			if err := writeBatch(writer, batch, checkpoint); err != nil {
+				return err
+			}
+			offset, err := out.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			checkpoint.Records += len(batch.records)
+			checkpoint.OutputOffset = offset
+			if err := writeBulkCheckpoint(opts.checkpoint, checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+
+	fmt.Fprintf(stderr, "hashed %d records with salt version %d\n", checkpoint.Records, checkpoint.SaltVersion)
+	if err := os.Remove(opts.checkpoint); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func readBatches(ctx context.Context, reader recordReader, recordsPerBatch int, batches chan<- *bulkBatch) error {
+	for index := 0; ; index++ {
+		batch := &bulkBatch{index: index}
+		for len(batch.records) < recordsPerBatch {
+			record, values, err := reader.read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			batch.records = append(batch.records, record)
+			batch.values = append(batch.values, values)
+			for _, value := range values {
+				if value != "" {
+					batch.inputs = append(batch.inputs, []byte(value))
+				}
+			}
+		}
+		if len(batch.records) == 0 {
+			return nil
+		}
+
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// writeBatch writes the records of the batch with their sums, empty values
+// being left empty. Sums of another salt version than the previous ones are
+// refused.
+func writeBatch(writer recordWriter, batch *bulkBatch, checkpoint *bulkCheckpoint) error {
+	for _, sum := range batch.sums {
+		if checkpoint.SaltVersion == 0 {
+			checkpoint.SaltVersion = sum.SaltVersion
+		}
+		if sum.SaltVersion != checkpoint.SaltVersion {
+			return fmt.Errorf("salt version changed from %d to %d, the role was rotated during the run",
+				checkpoint.SaltVersion, sum.SaltVersion)
+		}
+	}
+
+	next := 0
+	for i, record := range batch.records {
+		sums := make([]string, len(batch.values[i]))
+		for j, value := range batch.values[i] {
+			if value != "" {
+				sums[j] = batch.sums[next].Sum
+				next++
+			}
+		}
+		if err := writer.write(record, sums); err != nil {
+			return err
+		}
+	}
+
+	return writer.flush()
+}
+
+// hashBatchWithRetries hashes the inputs, retrying transient failures with
+// exponential backoff.
+func hashBatchWithRetries(ctx context.Context, c *client.Client, opts *bulkOptions, inputs [][]byte, stderr io.Writer) ([]client.Sum, error) {
+	backoff := bulkBackoff
+	for attempt := 0; ; attempt++ {
+		sums, err := c.HashBatch(ctx, opts.role, opts.algorithm, inputs)
+		if err == nil {
+			return sums, nil
+		}
+		if attempt == opts.retries || !isTransient(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(stderr, "retrying batch in %s: %v\n", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff *= 2; backoff > bulkMaxBackoff {
+			backoff = bulkMaxBackoff
+		}
+	}
+}
+
+// isTransient reports whether the request may succeed if retried: network
+// errors, rate limiting and server errors.
+func isTransient(err error) bool {
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == http.StatusTooManyRequests || respErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// recordReader reads records along with the values of their fields to hash.
+type recordReader interface {
+	read() (record interface{}, values []string, err error)
+}
+
+// recordWriter writes records with the values of their fields replaced.
+type recordWriter interface {
+	write(record interface{}, sums []string) error
+	flush() error
+}
+
+// newRecordCodec returns the reader and writer of the format of the records.
+// The CSV header is copied to the output unless resuming.
+func newRecordCodec(opts *bulkOptions, in io.Reader, out io.Writer, writeHeader bool) (recordReader, recordWriter, error) {
+	if opts.format == "ndjson" {
+		dec := json.NewDecoder(in)
+		dec.UseNumber()
+		return &jsonRecords{dec: dec, fields: opts.fields}, &jsonRecords{enc: json.NewEncoder(out), fields: opts.fields}, nil
+	}
+
+	r := csv.NewReader(in)
+	w := csv.NewWriter(out)
+	header := w
+	if !writeHeader {
+		header = nil
+	}
+	columns, err := readCSVHeader(r, header, opts.fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	records := &csvRecords{r: r, w: w, columns: columns}
+
+	return records, records, nil
+}
+
+type csvRecords struct {
+	r       *csv.Reader
+	w       *csv.Writer
+	columns []int
+}
+
+func (c *csvRecords) read() (interface{}, []string, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		values[i] = record[column]
+	}
+
+	return record, values, nil
+}
+
+func (c *csvRecords) write(record interface{}, sums []string) error {
+	fields := record.([]string)
+	for i, column := range c.columns {
+		fields[column] = sums[i]
+	}
+
+	return c.w.Write(fields)
+}
+
+func (c *csvRecords) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonRecords struct {
+	dec    *json.Decoder
+	enc    *json.Encoder
+	fields []string
+}
+
+func (j *jsonRecords) read() (interface{}, []string, error) {
+	var record map[string]interface{}
+	if err := j.dec.Decode(&record); err != nil {
+		return nil, nil, err
+	}
+
+	values, err := jsonFields(record, j.fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return record, values, nil
+}
+
+func (j *jsonRecords) write(record interface{}, sums []string) error {
+	fields := record.(map[string]interface{})
+	for i, field := range j.fields {
+		fields[field] = sums[i]
+	}
+
+	return j.enc.Encode(fields)
+}
+
+func (j *jsonRecords) flush() error {
+	return nil
+}
+
+// lockedWriter serializes the writes to w.
+type lockedWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.w.Write(p)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/client"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/internal/devserver"
+)
+
+const testSalt = "c2VjcmV0c2FsdA=="
+
+// testVault is an in-process stand-in of Vault serving a saltyhash mount
+// through the dev server. Requests to hash_batch are passed to fault first,
+// which fails them with the returned status code unless zero.
+type testVault struct {
+	sync.Mutex
+	hashRequests int
+	fault        func(request int) int
+}
+
+func newTestVault(t *testing.T) (*testVault, *client.Client) {
+	handler, err := devserver.New(context.Background(), "saltyhash", "root", &logical.InmemStorage{}, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tv := &testVault{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/saltyhash/hash_batch/") {
+			tv.Lock()
+			tv.hashRequests++
+			status := 0
+			if tv.fault != nil {
+				status = tv.fault(tv.hashRequests)
+			}
+			tv.Unlock()
+
+			if status != 0 {
+				w.WriteHeader(status)
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"injected fault"}})
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	vault, err := api.NewClient(&api.Config{Address: server.URL, MaxRetries: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.SetToken("root")
+
+	c := client.New(vault, "saltyhash")
+	if err := c.WriteRole(context.Background(), "test", &client.Role{Salt: testSalt, Mode: hasher.ModeAppend}); err != nil {
+		t.Fatal(err)
+	}
+
+	return tv, c
+}
+
+func (tv *testVault) setFault(fault func(request int) int) {
+	tv.Lock()
+	defer tv.Unlock()
+
+	tv.hashRequests = 0
+	tv.fault = fault
+}
+
+func (tv *testVault) requests() int {
+	tv.Lock()
+	defer tv.Unlock()
+
+	return tv.hashRequests
+}
+
+func init() {
+	bulkBackoff = time.Millisecond
+}
+
+func TestBulk_CSV(t *testing.T) {
+	tv, c := newTestVault(t)
+	dir := tempDir(t)
+
+	h, err := hasher.NewFromBase64(hasher.SHA2256, testSalt, hasher.ModeAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records with an empty value, which is left empty
+	var input, expected bytes.Buffer
+	in, out := csv.NewWriter(&input), csv.NewWriter(&expected)
+	_ = in.Write([]string{"id", "email", "name"})
+	_ = out.Write([]string{"id", "email", "name"})
+	for i := 0; i < 25; i++ {
+		email, name := fmt.Sprintf("user-%d@example.com", i), fmt.Sprintf("User %d", i)
+		nameSum := h.SumHex([]byte(name))
+		if i == 7 {
+			name, nameSum = "", ""
+		}
+		_ = in.Write([]string{fmt.Sprint(i), email, name})
+		_ = out.Write([]string{fmt.Sprint(i), h.SumHex([]byte(email)), nameSum})
+	}
+	in.Flush()
+	out.Flush()
+
+	opts := &bulkOptions{
+		role:        "test",
+		algorithm:   hasher.SHA2256,
+		format:      "csv",
+		fields:      []string{"email", "name"},
+		input:       filepath.Join(dir, "input.csv"),
+		output:      filepath.Join(dir, "output.csv"),
+		checkpoint:  filepath.Join(dir, "output.csv.checkpoint"),
+		batchSize:   4,
+		concurrency: 3,
+		retries:     2,
+	}
+	if err := ioutil.WriteFile(opts.input, input.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test transient failures are retried
+	tv.setFault(func(request int) int {
+		switch request {
+		case 2:
+			return http.StatusServiceUnavailable
+		case 5:
+			return http.StatusTooManyRequests
+		}
+		return 0
+	})
+
+	var stderr bytes.Buffer
+	if err := bulkHash(context.Background(), c, opts, &stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := ioutil.ReadFile(opts.output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, expected.Bytes()) {
+		t.Fatalf("bad output:\n%s\nexpected:\n%s", output, expected.Bytes())
+	}
+	// 13 batches of 2 records, and the 2 retries
+	if requests := tv.requests(); requests != 15 {
+		t.Fatalf("expected 15 requests, got %d", requests)
+	}
+	if _, err := os.Stat(opts.checkpoint); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint not removed: %v", err)
+	}
+
+	// Test a file of no records only gets its header
+	if err := ioutil.WriteFile(opts.input, []byte("id,email,name\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := bulkHash(context.Background(), c, opts, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := ioutil.ReadFile(opts.output); err != nil || string(output) != "id,email,name\n" {
+		t.Fatalf("bad output of no records: %q, err: %v", output, err)
+	}
+
+	// Test failures beyond the retries fail the run
+	if err := ioutil.WriteFile(opts.input, input.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tv.setFault(func(int) int { return http.StatusBadGateway })
+	if err := bulkHash(context.Background(), c, opts, &stderr); err == nil {
+		t.Fatal("expected error with persistent failures")
+	}
+	if requests := tv.requests(); requests < 3 {
+		t.Fatalf("expected at least 3 attempts, got %d", requests)
+	}
+}
+
+func TestBulk_Resume(t *testing.T) {
+	tv, c := newTestVault(t)
+	dir := tempDir(t)
+
+	h, err := hasher.NewFromBase64(hasher.SHA1, testSalt, hasher.ModeAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var input, expected bytes.Buffer
+	for i := 0; i < 20; i++ {
+		email := fmt.Sprintf("user-%d@example.com", i)
+		fmt.Fprintf(&input, `{"id":%d,"email":%q}`+"\n", i, email)
+		fmt.Fprintf(&expected, `{"email":%q,"id":%d}`+"\n", h.SumHex([]byte(email)), i)
+	}
+
+	opts := &bulkOptions{
+		role:        "test",
+		algorithm:   hasher.SHA1,
+		format:      "ndjson",
+		fields:      []string{"email"},
+		input:       filepath.Join(dir, "input.ndjson"),
+		output:      filepath.Join(dir, "output.ndjson"),
+		checkpoint:  filepath.Join(dir, "checkpoint"),
+		batchSize:   3,
+		concurrency: 1,
+	}
+	if err := ioutil.WriteFile(opts.input, input.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test a failure leaves a checkpoint after the batches written
+	tv.setFault(func(request int) int {
+		if request == 4 {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+
+	var stderr bytes.Buffer
+	if err := bulkHash(context.Background(), c, opts, &stderr); err == nil {
+		t.Fatal("expected error with a failed batch")
+	}
+	checkpoint, err := readBulkCheckpoint(opts.checkpoint)
+	if err != nil || checkpoint == nil {
+		t.Fatalf("missing checkpoint: %v", err)
+	}
+	if checkpoint.Records != 9 || checkpoint.SaltVersion != 1 {
+		t.Fatalf("bad checkpoint: %#v", checkpoint)
+	}
+
+	// Test a checkpoint of another run is refused
+	other := *opts
+	other.algorithm = hasher.SHA2256
+	if err := bulkHash(context.Background(), c, &other, &stderr); err == nil {
+		t.Fatal("expected error with a checkpoint of another run")
+	}
+
+	// Test the run resumes after the checkpoint, rewriting any output past it
+	f, err := os.OpenFile(opts.output, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"partial":`)
+	f.Close()
+
+	tv.setFault(nil)
+	if err := bulkHash(context.Background(), c, opts, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadFile(opts.output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, expected.Bytes()) {
+		t.Fatalf("bad output:\n%s\nexpected:\n%s", output, expected.Bytes())
+	}
+	if requests := tv.requests(); requests != 4 {
+		t.Fatalf("expected the 4 remaining batches to be requested, got %d", requests)
+	}
+	if !strings.Contains(stderr.String(), "resuming after 9 records") {
+		t.Fatalf("resume not reported: %s", stderr.String())
+	}
+}
+
+func TestBulk_Flags(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"-role", "test", "-input", "in.csv", "-output", "in.csv", "-fields", "email"},
+		{"-role", "test", "-input", "in.csv", "-output", "out.csv"},
+		{"-role", "test", "-input", "in.csv", "-output", "out.csv", "-fields", "email", "-format", "xml"},
+		{"-role", "test", "-input", "in.csv", "-output", "out.csv", "-fields", "email", "-concurrency", "0"},
+	} {
+		if code, _, _ := runOffline("bulk", args, ""); code != 2 {
+			t.Fatalf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+
+	opts, err := parseBulkFlags([]string{"-role", "test", "-input", "in.csv", "-output", "out.csv", "-fields", "email,name"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.checkpoint != "out.csv.checkpoint" || len(opts.fields) != 2 || opts.concurrency != 4 || opts.mount != "saltyhash" {
+		t.Fatalf("bad options: %#v", opts)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+const conformanceUsage = `Usage: saltyhash conformance -vectors vectors.json [options] [--] command [args...]
+
+Checks an implementation of saltyhash against the known-answer vectors of the
+plugin, found in hasher/testdata/vectors.json of its repository. The command
+is run once and sent the vectors on stdin, one JSON object per line:
+
+  {"name":"sha1/append/ascii","algorithm":"sha1","mode":"append","salt":"c2VjcmV0c2FsdA==","input":"c2VjcmV0ZGF0YQ=="}
+
+Salts and inputs are base64-encoded. The command must write the hex-encoded
+sum of every vector to stdout, one per line and in order, and exit with
+status 0. Mismatches are reported on stdout, and the command exits with
+status 1 if any.
+
+Options:
+`
+
+// conformanceVector is a vector as sent to the command checked, without its
+// sum.
+type conformanceVector struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Mode      string `json:"mode"`
+	Salt      string `json:"salt"`
+	Input     string `json:"input"`
+}
+
+func runConformance(args []string, _ io.Reader, stdout, stderr io.Writer) int {
+	var (
+		vectorsFile string
+		timeout     time.Duration
+	)
+
+	flags := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, conformanceUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&vectorsFile, "vectors", "", "Vectors file")
+	flags.DurationVar(&timeout, "timeout", time.Minute, "Time the command has to output every sum")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if vectorsFile == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	vectors, err := loadVectors(vectorsFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	sums, err := runImplementation(ctx, flags.Args(), vectors, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	mismatches := 0
+	for i, vector := range vectors.Vectors {
+		if sums[i] != vector.Sum {
+			mismatches++
+			fmt.Fprintf(stdout, "vector %s: sum %s, expected %s\n", vector.Name, sums[i], vector.Sum)
+		}
+	}
+
+	fmt.Fprintf(stderr, "%d vectors of version %d checked, %d mismatches\n", len(vectors.Vectors), vectors.Version, mismatches)
+	if mismatches > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// loadVectors reads the vectors file, checking it against this release so
+// implementations aren't checked against sums the plugin doesn't compute.
+func loadVectors(path string) (*hasher.Vectors, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vectors, err := hasher.ReadVectors(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, vector := range vectors.Vectors {
+		if err := vector.Check(); err != nil {
+			return nil, fmt.Errorf("vectors file doesn't match this release: %w", err)
+		}
+	}
+
+	return vectors, nil
+}
+
+// runImplementation runs the command with the vectors on stdin and returns
+// the sums it output, one for each vector.
+func runImplementation(ctx context.Context, command []string, vectors *hasher.Vectors, stderr io.Writer) ([]string, error) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// The vectors are written while the sums are read, so a command writing
+	// sums as it goes doesn't block on a full pipe.
+	go func() {
+		w := bufio.NewWriter(stdin)
+		enc := json.NewEncoder(w)
+		for _, vector := range vectors.Vectors {
+			if err := enc.Encode(conformanceVector{
+				Name:      vector.Name,
+				Algorithm: vector.Algorithm,
+				Mode:      vector.Mode,
+				Salt:      vector.Salt,
+				Input:     vector.Input,
+			}); err != nil {
+				break
+			}
+		}
+		_ = w.Flush()
+		_ = stdin.Close()
+	}()
+
+	var sums []string
+	readErr := eachLine(out, func(line string) error {
+		if len(sums) == len(vectors.Vectors) {
+			return errors.New("command output more sums than vectors")
+		}
+		sums = append(sums, line)
+		return nil
+	})
+	if readErr != nil {
+		// Unblock the command, which is killed if it doesn't exit.
+		_, _ = io.Copy(ioutil.Discard, out)
+	}
+	if err := cmd.Wait(); ctx.Err() != nil {
+		return nil, fmt.Errorf("command timed out: %w", ctx.Err())
+	} else if err != nil {
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if len(sums) != len(vectors.Vectors) {
+		return nil, fmt.Errorf("command output %d sums, expected %d", len(sums), len(vectors.Vectors))
+	}
+
+	return sums, nil
+}
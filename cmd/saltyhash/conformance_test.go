@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+const testVectorsFile = "../../hasher/testdata/vectors.json"
+
+// TestConformance_Implementation is the implementation checked by
+// TestConformance, run by the conformance command as a separate process of
+// the test binary. It computes the sums with the hasher package, and fails
+// as told by its environment.
+func TestConformance_Implementation(t *testing.T) {
+	behavior := os.Getenv("SALTYHASH_TEST_IMPLEMENTATION")
+	if behavior == "" {
+		return
+	}
+
+	dec := json.NewDecoder(os.Stdin)
+	for i := 0; dec.More(); i++ {
+		var vector conformanceVector
+		if err := dec.Decode(&vector); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(3)
+		}
+
+		h, err := hasher.NewFromBase64(vector.Algorithm, vector.Salt, vector.Mode)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(3)
+		}
+		input, _ := hasher.DecodeInput(vector.Input)
+		sum := h.SumHex(input)
+
+		switch {
+		case behavior == "mismatch" && i == 1:
+			sum = strings.ToUpper(sum)
+		case behavior == "short" && i == 1:
+			os.Exit(0)
+		case behavior == "fail" && i == 1:
+			os.Exit(1)
+		}
+		fmt.Println(sum)
+	}
+
+	// Exit before the test framework writes to stdout.
+	os.Exit(0)
+}
+
+func TestConformance(t *testing.T) {
+	runImplementation := func(behavior string, args ...string) (int, string, string) {
+		os.Setenv("SALTYHASH_TEST_IMPLEMENTATION", behavior)
+		defer os.Unsetenv("SALTYHASH_TEST_IMPLEMENTATION")
+
+		args = append(args, "--", os.Args[0], "-test.run=TestConformance_Implementation")
+		return runOffline("conformance", args, "")
+	}
+
+	vectors, err := loadVectors(testVectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test a conforming implementation
+	code, stdout, stderr := runImplementation("conforming", "-vectors", testVectorsFile)
+	if code != 0 || stdout != "" {
+		t.Fatalf("expected conformance, got %d: %s %s", code, stdout, stderr)
+	}
+	if expected := fmt.Sprintf("%d vectors of version 1 checked, 0 mismatches", len(vectors.Vectors)); !strings.Contains(stderr, expected) {
+		t.Fatalf("bad report: %s", stderr)
+	}
+
+	// Test mismatches are reported, sums being compared as output by the
+	// plugin
+	code, stdout, _ = runImplementation("mismatch", "-vectors", testVectorsFile)
+	if code != 1 || !strings.HasPrefix(stdout, "vector "+vectors.Vectors[1].Name+": sum ") || strings.Count(stdout, "\n") != 1 {
+		t.Fatalf("expected a mismatch, got %d: %s", code, stdout)
+	}
+
+	// Test missing sums and failures of the command
+	if code, _, stderr := runImplementation("short", "-vectors", testVectorsFile); code != 2 || !strings.Contains(stderr, "command output 1 sums") {
+		t.Fatalf("expected error with missing sums, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := runImplementation("fail", "-vectors", testVectorsFile); code != 2 || !strings.Contains(stderr, "command failed") {
+		t.Fatalf("expected error with a failed command, got %d: %s", code, stderr)
+	}
+
+	// Test a vectors file this release doesn't compute is refused
+	vectors.Vectors[0].Sum = strings.Repeat("0", len(vectors.Vectors[0].Sum))
+	raw, err := json.Marshal(vectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(tempDir(t), "vectors.json")
+	if err := ioutil.WriteFile(path, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, stderr := runImplementation("conforming", "-vectors", path); code != 2 || !strings.Contains(stderr, "doesn't match this release") {
+		t.Fatalf("expected error with other vectors, got %d: %s", code, stderr)
+	}
+
+	// Test usage errors
+	for _, args := range [][]string{nil, {"-vectors", testVectorsFile}, {"--", "true"}} {
+		if code, _, _ := runOffline("conformance", args, ""); code != 2 {
+			t.Fatalf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/sdk/physical/file"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/internal/devserver"
+)
+
+const devUsage = `Usage: saltyhash dev [options]
+
+Serves the backend behind a minimal local HTTP server mimicking the Vault API
+of a mount, for testing applications without Vault. Requests are made to
+/v1/<mount>/... with the dev token in the X-Vault-Token header, and responses
+are wrapped in the JSON envelope of Vault. Wrapped responses are unwrapped
+through /v1/sys/wrapping/unwrap.
+
+This is not Vault: there are no policies, audit, leases nor seal, and the
+storage isn't encrypted. Never use it with production salts.
+
+Options:
+`
+
+// devPeriod is the interval of the periodic function of the backend, as
+// run by Vault.
+const devPeriod = time.Minute
+
+func runDev(args []string, _ io.Reader, stdout, stderr io.Writer) int {
+	var (
+		listen     string
+		mount      string
+		token      string
+		storageDir string
+	)
+
+	flags := flag.NewFlagSet("dev", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, devUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&listen, "listen", "127.0.0.1:8200", "Address to listen on")
+	flags.StringVar(&mount, "mount", "saltyhash", "Path of the mount")
+	flags.StringVar(&token, "token", "root", "Token accepted by the server")
+	flags.StringVar(&storageDir, "storage-dir", "", "Directory to store the backend data in, in memory if unset")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{Name: "saltyhash", Output: stderr, Level: hclog.Info})
+
+	storage := logical.Storage(&logical.InmemStorage{})
+	if storageDir != "" {
+		physical, err := file.NewFileBackend(map[string]string{"path": storageDir}, logger)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		storage = logical.NewLogicalStorage(physical)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler, err := devserver.New(ctx, mount, token, storage, logger)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	go handler.RunPeriodic(ctx, devPeriod)
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	server := &http.Server{Handler: handler}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		<-interrupts
+		shutdownCtx, cancelShutdown := context.WithTimeout(ctx, 5*time.Second)
+		defer cancelShutdown()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(stdout, "Serving the %s mount on http://%s\n\n", mount, listener.Addr())
+	fmt.Fprintf(stdout, "    export VAULT_ADDR=http://%s\n    export VAULT_TOKEN=%s\n\n", listener.Addr(), token)
+
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
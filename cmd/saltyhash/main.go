@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"os"
 
 	"github.com/hashicorp/go-hclog"
@@ -9,7 +10,24 @@ import (
 	saltyhash "github.com/unflag/vault-plugin-secrets-saltyhash"
 )
 
+// commands are the subcommands run instead of serving the plugin.
+var commands = map[string]func(args []string, stdin io.Reader, stdout, stderr io.Writer) int{
+	"compute":     runCompute,
+	"verify":      runVerify,
+	"bulk":        runBulk,
+	"dev":         runDev,
+	"conformance": runConformance,
+}
+
 func main() {
+	// Vault launches the plugin with flags only, so a subcommand as first
+	// argument is run instead of serving the plugin.
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		}
+	}
+
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
 	_ = flags.Parse(os.Args[1:])
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+const offlineUsage = `Usage: saltyhash compute|verify [options] < records
+
+Computes and verifies sums locally, with the salt of a role exported through
+its export_salt endpoint and unwrapped to a file:
+
+  vault unwrap -format=json <token> > role.json
+
+Commands:
+  compute  Output the sums of the input records
+  verify   Check the sums of the input records, exiting with status 1 on
+           mismatches
+
+Records are read from stdin in one of the formats:
+  lines   One input per line for compute, an input and its sum separated by a
+          tab for verify. Sums are output one per line.
+  csv     CSV with a header row. compute replaces the columns with their
+          sums, verify compares the columns with the sum columns.
+  ndjson  One JSON object per line. compute replaces the string fields with
+          their sums, verify compares the fields with the sum fields.
+
+Options:
+`
+
+// offlineOptions are the options shared by the offline commands.
+type offlineOptions struct {
+	roleFile  string
+	algorithm string
+	format    string
+	fields    []string
+	sumFields []string
+	base64    bool
+}
+
+func parseOfflineFlags(command string, args []string, stderr io.Writer) (*offlineOptions, error) {
+	opts := &offlineOptions{}
+	var fields, sumFields string
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, offlineUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.roleFile, "role-file", "", "Exported role file, either the unwrapped secret or its data")
+	flags.StringVar(&opts.algorithm, "algorithm", hasher.SHA2256, "Algorithm, one of "+strings.Join(hasher.Algorithms(), ", "))
+	flags.StringVar(&opts.format, "format", "lines", "Record format, one of lines, csv, ndjson")
+	flags.StringVar(&fields, "fields", "", "Comma-separated input columns or fields of csv and ndjson records")
+	flags.StringVar(&sumFields, "sum-fields", "", "Comma-separated sum columns or fields to verify, in the order of -fields")
+	flags.BoolVar(&opts.base64, "base64", false, "Inputs are base64-encoded, as sent to the plugin API")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if opts.roleFile == "" {
+		return nil, errors.New("missing -role-file")
+	}
+	if fields != "" {
+		opts.fields = strings.Split(fields, ",")
+	}
+	if sumFields != "" {
+		opts.sumFields = strings.Split(sumFields, ",")
+	}
+
+	switch opts.format {
+	case "lines":
+		if len(opts.fields) > 0 || len(opts.sumFields) > 0 {
+			return nil, errors.New("-fields and -sum-fields don't apply to lines")
+		}
+	case "csv", "ndjson":
+		if len(opts.fields) == 0 {
+			return nil, fmt.Errorf("missing -fields of %s records", opts.format)
+		}
+		if command == "verify" && len(opts.sumFields) != len(opts.fields) {
+			return nil, errors.New("-sum-fields must name the sum of every field of -fields")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %s", opts.format)
+	}
+
+	return opts, nil
+}
+
+// exportedRole is the data of the export_salt endpoint of a role.
+type exportedRole struct {
+	Salt        string `json:"salt"`
+	SaltVersion int    `json:"salt_version"`
+	Mode        string `json:"mode"`
+}
+
+// loadRoleFile returns the hasher of the exported role in the file, which
+// holds either the unwrapped secret or only its data.
+func loadRoleFile(path, algorithm string) (*hasher.Hasher, *exportedRole, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var file struct {
+		exportedRole
+		Data *exportedRole `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, nil, fmt.Errorf("invalid role file: %w", err)
+	}
+	role := &file.exportedRole
+	if file.Data != nil {
+		role = file.Data
+	}
+	if role.Salt == "" || role.Mode == "" {
+		return nil, nil, errors.New("invalid role file: missing salt or mode")
+	}
+
+	h, err := hasher.NewFromBase64(algorithm, role.Salt, role.Mode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return h, role, nil
+}
+
+// decodeInput decodes an input of a record, refusing empty inputs with the
+// error of the hash endpoint of the plugin.
+func (o *offlineOptions) decodeInput(s string) ([]byte, error) {
+	var (
+		input = []byte(s)
+		err   error
+	)
+	if o.base64 {
+		input, err = hasher.DecodeInput(s)
+	}
+	if len(input) == 0 || err != nil {
+		return nil, fmt.Errorf("input either empty or contains invalid base64: %v", err)
+	}
+
+	return input, nil
+}
+
+func runCompute(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, err := parseOfflineFlags("compute", args, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	h, _, err := loadRoleFile(opts.roleFile, opts.algorithm)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	out := bufio.NewWriter(stdout)
+	err = eachRecord(opts, stdin, out, func(values []string) ([]string, error) {
+		sums := make([]string, len(values))
+		for i, value := range values {
+			input, err := opts.decodeInput(value)
+			if err != nil {
+				return nil, err
+			}
+			sums[i] = h.SumHex(input)
+		}
+		return sums, nil
+	})
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	return 0
+}
+
+func runVerify(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, err := parseOfflineFlags("verify", args, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	h, role, err := loadRoleFile(opts.roleFile, opts.algorithm)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	records, mismatches := 0, 0
+	err = eachVerifyRecord(opts, stdin, func(inputs, sums []string) error {
+		records++
+		for i, value := range inputs {
+			input, err := opts.decodeInput(value)
+			if err != nil {
+				return fmt.Errorf("record %d: %w", records, err)
+			}
+			sum, err := hasher.DecodeSum(sums[i])
+			if err != nil {
+				return fmt.Errorf("record %d: invalid hex sum: %w", records, err)
+			}
+			if !h.Verify(input, sum) {
+				mismatches++
+				fmt.Fprintf(stdout, "record %d: mismatch, expected %s\n", records, h.SumHex(input))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	fmt.Fprintf(stderr, "%d records verified against salt version %d, %d mismatches\n", records, role.SaltVersion, mismatches)
+	if mismatches > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// eachRecord reads the records and writes them with the values of the input
+// fields replaced by the result of fn, the sums only for lines.
+func eachRecord(opts *offlineOptions, in io.Reader, out io.Writer, fn func(values []string) ([]string, error)) error {
+	switch opts.format {
+	case "csv":
+		r := csv.NewReader(in)
+		w := csv.NewWriter(out)
+		columns, err := readCSVHeader(r, w, opts.fields)
+		if err != nil {
+			return err
+		}
+
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			values := make([]string, len(columns))
+			for i, column := range columns {
+				values[i] = record[column]
+			}
+			sums, err := fn(values)
+			if err != nil {
+				return err
+			}
+			for i, column := range columns {
+				record[column] = sums[i]
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+
+		w.Flush()
+		return w.Error()
+
+	case "ndjson":
+		enc := json.NewEncoder(out)
+		return eachJSONRecord(in, func(record map[string]interface{}) error {
+			values, err := jsonFields(record, opts.fields)
+			if err != nil {
+				return err
+			}
+			sums, err := fn(values)
+			if err != nil {
+				return err
+			}
+			for i, field := range opts.fields {
+				record[field] = sums[i]
+			}
+			return enc.Encode(record)
+		})
+
+	default:
+		return eachLine(in, func(line string) error {
+			sums, err := fn([]string{line})
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(out, sums[0])
+			return err
+		})
+	}
+}
+
+// eachVerifyRecord reads the records and calls fn with the values of their
+// input fields and sum fields.
+func eachVerifyRecord(opts *offlineOptions, in io.Reader, fn func(inputs, sums []string) error) error {
+	switch opts.format {
+	case "csv":
+		r := csv.NewReader(in)
+		columns, err := readCSVHeader(r, nil, append(append([]string(nil), opts.fields...), opts.sumFields...))
+		if err != nil {
+			return err
+		}
+
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			values := make([]string, len(columns))
+			for i, column := range columns {
+				values[i] = record[column]
+			}
+			if err := fn(values[:len(opts.fields)], values[len(opts.fields):]); err != nil {
+				return err
+			}
+		}
+
+	case "ndjson":
+		return eachJSONRecord(in, func(record map[string]interface{}) error {
+			inputs, err := jsonFields(record, opts.fields)
+			if err != nil {
+				return err
+			}
+			sums, err := jsonFields(record, opts.sumFields)
+			if err != nil {
+				return err
+			}
+			return fn(inputs, sums)
+		})
+
+	default:
+		return eachLine(in, func(line string) error {
+			i := strings.LastIndexByte(line, '\t')
+			if i < 0 {
+				return errors.New("line without a tab separating the input from its sum")
+			}
+			return fn([]string{line[:i]}, []string{line[i+1:]})
+		})
+	}
+}
+
+// readCSVHeader returns the indexes of the columns in the header row, which
+// is copied to w if set.
+func readCSVHeader(r *csv.Reader, w *csv.Writer, names []string) ([]int, error) {
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read csv header: %w", err)
+	}
+	if w != nil {
+		if err := w.Write(header); err != nil {
+			return nil, err
+		}
+	}
+
+	columns := make([]int, len(names))
+	for i, name := range names {
+		columns[i] = -1
+		for j, column := range header {
+			if column == name {
+				columns[i] = j
+			}
+		}
+		if columns[i] < 0 {
+			return nil, fmt.Errorf("column %s not found in csv header", name)
+		}
+	}
+
+	return columns, nil
+}
+
+func eachJSONRecord(in io.Reader, fn func(record map[string]interface{}) error) error {
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+	for {
+		var record map[string]interface{}
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// jsonFields returns the values of the fields of the record, which must be
+// strings.
+func jsonFields(record map[string]interface{}, fields []string) ([]string, error) {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		value, ok := record[field].(string)
+		if !ok {
+			return nil, fmt.Errorf("field %s missing or not a string", field)
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+func eachLine(in io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if err := fn(strings.TrimSuffix(scanner.Text(), "\r")); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	// The sums of "secretdata" and "other" salted with "secretsalt" appended.
+	testSum      = "675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98"
+	testOtherSum = "6ee96e4ef6448cc53d066c1a1d2071ecca4be356b74dd4bd1a743e03450930a7"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "saltyhash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeRoleFile(t *testing.T, content string) string {
+	path := filepath.Join(tempDir(t), "role.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runOffline(command string, args []string, stdin string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := commands[command](args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCompute(t *testing.T) {
+	// The output of vault unwrap -format=json
+	roleFile := writeRoleFile(t, `{"request_id":"x","data":{"salt":"c2VjcmV0c2FsdA==","salt_version":1,"mode":"append"}}`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		expected string
+	}{
+		{
+			"lines",
+			nil,
+			"secretdata\nother\n",
+			testSum + "\n" + testOtherSum + "\n",
+		},
+		{
+			"lines in base64",
+			[]string{"-base64"},
+			"c2VjcmV0ZGF0YQ==\r\n",
+			testSum + "\n",
+		},
+		{
+			"csv",
+			[]string{"-format", "csv", "-fields", "email"},
+			"id,email\n1,secretdata\n2,other\n",
+			"id,email\n1," + testSum + "\n2," + testOtherSum + "\n",
+		},
+		{
+			"ndjson",
+			[]string{"-format", "ndjson", "-fields", "email,name"},
+			`{"id":1,"email":"secretdata","name":"other"}` + "\n",
+			`{"email":"` + testSum + `","id":1,"name":"` + testOtherSum + `"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runOffline("compute", append([]string{"-role-file", roleFile}, tt.args...), tt.stdin)
+			if code != 0 {
+				t.Fatalf("compute failed with %d: %s", code, stderr)
+			}
+			if stdout != tt.expected {
+				t.Fatalf("bad output:\n%s\nexpected:\n%s", stdout, tt.expected)
+			}
+		})
+	}
+
+	// Test invalid usage fails
+	for _, args := range [][]string{
+		nil,
+		{"-role-file", filepath.Join(tempDir(t), "missing.json")},
+		{"-role-file", roleFile, "-algorithm", "md5"},
+		{"-role-file", roleFile, "-format", "xml"},
+		{"-role-file", roleFile, "-format", "csv"},
+		{"-role-file", roleFile, "-fields", "email"},
+	} {
+		if code, _, _ := runOffline("compute", args, "secretdata\n"); code != 2 {
+			t.Fatalf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+	if code, _, _ := runOffline("compute", []string{"-role-file", roleFile, "-format", "csv", "-fields", "missing"}, "id,email\n"); code != 2 {
+		t.Fatalf("expected exit code 2 with a missing column, got %d", code)
+	}
+	if code, _, _ := runOffline("compute", []string{"-role-file", writeRoleFile(t, `{"mode":"append"}`)}, "secretdata\n"); code != 2 {
+		t.Fatalf("expected exit code 2 with a role file without salt, got %d", code)
+	}
+
+	// Test empty inputs are refused as by the hash endpoint
+	for _, tt := range []struct {
+		args  []string
+		stdin string
+	}{
+		{nil, "secretdata\n\nother\n"},
+		{[]string{"-base64"}, "c2VjcmV0ZGF0YQ==\n\n"},
+		{[]string{"-format", "csv", "-fields", "email"}, "id,email\n1,\n"},
+		{[]string{"-format", "ndjson", "-fields", "email"}, `{"email":""}` + "\n"},
+	} {
+		code, _, stderr := runOffline("compute", append([]string{"-role-file", roleFile}, tt.args...), tt.stdin)
+		if code != 2 || !strings.Contains(stderr, "input either empty or contains invalid base64") {
+			t.Fatalf("%v: expected empty input error, got exit code %d: %s", tt.args, code, stderr)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	// The data of the export_salt response alone
+	roleFile := writeRoleFile(t, `{"salt":"c2VjcmV0c2FsdA==","salt_version":3,"mode":"append"}`)
+
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		code       int
+		mismatches int
+	}{
+		{"lines", nil, "secretdata\t" + testSum + "\n", 0, 0},
+		{"lines mismatch", nil, "secretdata\t" + testSum + "\nother\t" + testSum + "\n", 1, 1},
+		{"lines without sum", nil, "secretdata\n", 2, 0},
+		{"lines with invalid sum", nil, "secretdata\tnothex\n", 2, 0},
+		{
+			"csv",
+			[]string{"-format", "csv", "-fields", "email", "-sum-fields", "email_sum"},
+			"email,email_sum\nsecretdata," + testSum + "\nother," + testOtherSum + "\nother," + testSum + "\n",
+			1, 1,
+		},
+		{
+			"ndjson",
+			[]string{"-format", "ndjson", "-fields", "email", "-sum-fields", "sum"},
+			`{"email":"secretdata","sum":"` + testSum + `"}` + "\n",
+			0, 0,
+		},
+		{
+			"ndjson without sum fields",
+			[]string{"-format", "ndjson", "-fields", "email"},
+			`{"email":"secretdata"}` + "\n",
+			2, 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runOffline("verify", append([]string{"-role-file", roleFile}, tt.args...), tt.stdin)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, got %d: %s", tt.code, code, stderr)
+			}
+			if mismatches := strings.Count(stdout, "mismatch"); mismatches != tt.mismatches {
+				t.Fatalf("expected %d mismatches, got output: %s", tt.mismatches, stdout)
+			}
+			if code != 2 && !strings.Contains(stderr, "salt version 3") {
+				t.Fatalf("bad summary: %s", stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+//go:build go1.18
+// +build go1.18
+
+package saltyhash
+
+import (
+	"testing"
+
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+func FuzzHash(f *testing.F) {
+	b, storage := createBackendWithStorage(f)
+	createModeRoles(f, b, storage)
+
+	f.Add(uint8(0), false, testSecret, "c2VjcmV0ZGF0YQ==")
+	f.Add(uint8(1), true, "YWJjZGVmZ2hpams=", "YQ==")
+	f.Add(uint8(2), false, "YQ", "")
+	f.Add(uint8(3), true, "Y Q==", "YQ==YQ==")
+
+	f.Fuzz(func(t *testing.T, a uint8, prepend bool, first, second string) {
+		role, h := modeRoleHasher(t, a, prepend)
+		checkHashRequests(t, b, storage, role, h, []string{first, second})
+	})
+}
+
+func FuzzRehash(f *testing.F) {
+	b, storage := createBackendWithStorage(f)
+	createModeRoles(f, b, storage)
+
+	h, err := hasher.NewFromBase64(hasher.SHA2256, testSalt, hasher.ModeAppend)
+	if err != nil {
+		f.Fatal(err)
+	}
+	input, _ := hasher.DecodeInput(testSecret)
+	f.Add(false, testSecret, h.SumHex(input), 1, hasher.SHA2256, hasher.SHA3512)
+	f.Add(false, testSecret, h.SumHex(input), 2, hasher.SHA2256, "")
+	f.Add(true, testSecret, h.SumHex(input), 1, hasher.SHA2256, "md5")
+	f.Add(false, "", "", 0, "", "")
+
+	f.Fuzz(func(t *testing.T, prepend bool, inputB64, sumHex string, saltVersion int, algorithm, newAlgorithm string) {
+		role, _ := modeRoleHasher(t, 0, prepend)
+		checkRehashRequest(t, b, storage, role, inputB64, sumHex, saltVersion, algorithm, newAlgorithm)
+	})
+}
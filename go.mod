@@ -5,12 +5,15 @@ replace github.com/hashicorp/vault/api => github.com/hashicorp/vault/api v0.0.0-
 go 1.14
 
 require (
+	github.com/armon/go-metrics v0.3.3
 	github.com/hashicorp/go-hclog v0.14.1
+	github.com/hashicorp/go-multierror v1.1.0
 	github.com/hashicorp/vault v1.5.0
 	github.com/hashicorp/vault/api v1.0.5-0.20200630205458-1a16f3c699c6
 	github.com/hashicorp/vault/sdk v0.1.14-0.20200718021857-871b5365aa35
 	github.com/mitchellh/mapstructure v1.3.3
 	github.com/morikuni/aec v1.0.0 // indirect
 	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
+	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
 	gotest.tools/v3 v3.0.2 // indirect
 )
@@ -0,0 +1,89 @@
+//go:build go1.18
+// +build go1.18
+
+package hasher
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func FuzzHasher(f *testing.F) {
+	index := make(map[string]uint8)
+	for i, algorithmName := range Algorithms() {
+		index[algorithmName] = uint8(i)
+	}
+	for i, vector := range readTestVectors(f).Vectors {
+		salt, _ := DecodeInput(vector.Salt)
+		input, _ := DecodeInput(vector.Input)
+		f.Add(index[vector.Algorithm], salt, vector.Mode == ModePrepend, input, uint8(i))
+	}
+
+	f.Fuzz(func(t *testing.T, a uint8, salt []byte, prepend bool, input []byte, spare uint8) {
+		if !checkHasher(t, a, salt, prepend, input, spare) {
+			t.Fatal("sum of a fresh concatenation not matched, or buffers written to")
+		}
+	})
+}
+
+func FuzzNew(f *testing.F) {
+	f.Add(SHA2256, ModeAppend)
+	f.Add("md5", ModePrepend)
+	f.Add(SHA3512, "middle")
+
+	f.Fuzz(func(t *testing.T, algorithmName, mode string) {
+		h, err := New(algorithmName, []byte("salt"), mode)
+		switch {
+		case !IsSupportedAlgorithm(algorithmName):
+			if !errors.Is(err, ErrUnsupportedAlgorithm) {
+				t.Fatalf("expected unsupported algorithm error, got: %v", err)
+			}
+		case !IsValidMode(mode):
+			if !errors.Is(err, ErrInvalidMode) {
+				t.Fatalf("expected invalid mode error, got: %v", err)
+			}
+		case err != nil:
+			t.Fatal(err)
+		case len(h.Sum([]byte("input"))) != h.Size():
+			t.Fatalf("%s: sum not of %d bytes", algorithmName, h.Size())
+		}
+	})
+}
+
+func FuzzDecodeInput(f *testing.F) {
+	for _, s := range []string{"c2VjcmV0ZGF0YQ==", "YQ==", "YWI=", "", "!", "YQ", "YR==", "Y\r\nQ=="} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		input, err := DecodeInput(s)
+		if err != nil {
+			return
+		}
+
+		// Decoding is lenient, so only decoded inputs round-trip
+		again, err := DecodeInput(EncodeInput(input))
+		if err != nil || !bytes.Equal(again, input) {
+			t.Fatalf("%q: input %x doesn't round-trip: %x, err: %v", s, input, again, err)
+		}
+	})
+}
+
+func FuzzDecodeSum(f *testing.F) {
+	for _, s := range []string{"675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98", "ABCDEF", "", "0", "0g"} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		sum, err := DecodeSum(s)
+		if err != nil {
+			return
+		}
+
+		if encoded := EncodeSum(sum); encoded != strings.ToLower(s) {
+			t.Fatalf("%q: sum encoded as %q", s, encoded)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+// Package hasher computes saltyhash sums outside of Vault.
+//
+// The plugin hashes with this package, so a Hasher built from the salt and
+// mode of a role computes the same sums as the plugin does for that role.
+// Salts are exchanged base64-encoded and sums hex-encoded, as in the plugin
+// API.
+package hasher
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Supported algorithms.
+const (
+	SHA1    = "sha1"
+	SHA2256 = "sha2-256"
+	SHA2512 = "sha2-512"
+	SHA3256 = "sha3-256"
+	SHA3512 = "sha3-512"
+)
+
+// Salting modes, which place the salt after or before the input.
+const (
+	ModeAppend  = "append"
+	ModePrepend = "prepend"
+)
+
+// MaxSize is the size in bytes of the largest sum of the supported
+// algorithms.
+const MaxSize = sha512.Size
+
+var (
+	// ErrUnsupportedAlgorithm is returned for algorithms not in the registry.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+	// ErrInvalidMode is returned for salting modes other than ModeAppend and
+	// ModePrepend.
+	ErrInvalidMode = errors.New("invalid salt mode")
+
+	// ErrStreamingUnsupported is returned streaming with algorithms whose
+	// state can't be marshaled.
+	ErrStreamingUnsupported = errors.New("streaming unsupported by algorithm")
+)
+
+// algorithm is an entry of the registry. Hash functions are pooled as they
+// are costly to allocate compared to hashing short inputs.
+type algorithm struct {
+	size    int
+	pool    *sync.Pool
+	newHash func() hash.Hash
+
+	// streamable is set if the state of the hash function can be marshaled,
+	// see Stream.
+	streamable bool
+}
+
+func newAlgorithm(newHash func() hash.Hash) *algorithm {
+	hf := newHash()
+	_, marshaler := hf.(encoding.BinaryMarshaler)
+	_, unmarshaler := hf.(encoding.BinaryUnmarshaler)
+
+	return &algorithm{
+		size:       hf.Size(),
+		pool:       &sync.Pool{New: func() interface{} { return newHash() }},
+		newHash:    newHash,
+		streamable: marshaler && unmarshaler,
+	}
+}
+
+// algorithms is the registry of supported algorithms, listed in order in
+// algorithmNames.
+var (
+	algorithms = map[string]*algorithm{
+		SHA1:    newAlgorithm(sha1.New),
+		SHA2256: newAlgorithm(sha256.New),
+		SHA2512: newAlgorithm(sha512.New),
+		SHA3256: newAlgorithm(sha3.New256),
+		SHA3512: newAlgorithm(sha3.New512),
+	}
+	algorithmNames = []string{SHA1, SHA2256, SHA2512, SHA3256, SHA3512}
+	modeNames      = []string{ModeAppend, ModePrepend}
+)
+
+// Algorithms returns the names of the supported algorithms.
+func Algorithms() []string {
+	return append([]string(nil), algorithmNames...)
+}
+
+// Modes returns the names of the salting modes.
+func Modes() []string {
+	return append([]string(nil), modeNames...)
+}
+
+// IsSupportedAlgorithm reports whether the algorithm is supported.
+func IsSupportedAlgorithm(name string) bool {
+	_, ok := algorithms[name]
+	return ok
+}
+
+// IsValidMode reports whether the mode is a salting mode.
+func IsValidMode(mode string) bool {
+	return mode == ModeAppend || mode == ModePrepend
+}
+
+// Hasher computes the salted sums of one algorithm, salt and mode. It is
+// safe for concurrent use.
+type Hasher struct {
+	algorithm *algorithm
+	name      string
+	salt      []byte
+	mode      string
+}
+
+// New returns a Hasher of the algorithm salting inputs with the salt in the
+// given mode. The salt is copied.
+func New(algorithmName string, salt []byte, mode string) (*Hasher, error) {
+	a, ok := algorithms[algorithmName]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedAlgorithm, algorithmName)
+	}
+	if !IsValidMode(mode) {
+		return nil, fmt.Errorf("%w %s", ErrInvalidMode, mode)
+	}
+
+	return &Hasher{
+		algorithm: a,
+		name:      algorithmName,
+		salt:      append([]byte(nil), salt...),
+		mode:      mode,
+	}, nil
+}
+
+// NewFromBase64 is like New with a base64-encoded salt, as stored on roles
+// and returned by salt exports.
+func NewFromBase64(algorithmName, saltB64, mode string) (*Hasher, error) {
+	salt, err := base64.StdEncoding.DecodeString(saltB64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 salt: %w", err)
+	}
+
+	return New(algorithmName, salt, mode)
+}
+
+// Algorithm returns the name of the algorithm of the Hasher.
+func (h *Hasher) Algorithm() string {
+	return h.name
+}
+
+// Mode returns the salting mode of the Hasher.
+func (h *Hasher) Mode() string {
+	return h.mode
+}
+
+// Size returns the size in bytes of the sums of the Hasher.
+func (h *Hasher) Size() int {
+	return h.algorithm.size
+}
+
+// AppendSum appends the salted sum of the input to dst and returns the
+// resulting slice. Given a dst with enough capacity, such as a [MaxSize]byte
+// array sliced to zero length, it doesn't allocate.
+func (h *Hasher) AppendSum(dst, input []byte) []byte {
+	hf := h.algorithm.pool.Get().(hash.Hash)
+	defer h.algorithm.pool.Put(hf)
+
+	hf.Reset()
+
+	// The salt and the input are written one after the other, so neither is
+	// copied nor modified. hash.Hash writes never fail.
+	if h.mode == ModePrepend {
+		_, _ = hf.Write(h.salt)
+		_, _ = hf.Write(input)
+	} else {
+		_, _ = hf.Write(input)
+		_, _ = hf.Write(h.salt)
+	}
+
+	return hf.Sum(dst)
+}
+
+// Sum returns the salted sum of the input.
+func (h *Hasher) Sum(input []byte) []byte {
+	return h.AppendSum(make([]byte, 0, h.Size()), input)
+}
+
+// SumHex returns the salted sum of the input hex-encoded, as returned by the
+// plugin.
+func (h *Hasher) SumHex(input []byte) string {
+	var sum [MaxSize]byte
+	return EncodeSum(h.AppendSum(sum[:0], input))
+}
+
+// Verify reports whether sum is the salted sum of the input, in constant
+// time with respect to the sums.
+func (h *Hasher) Verify(input, sum []byte) bool {
+	var expected [MaxSize]byte
+	return subtle.ConstantTimeCompare(h.AppendSum(expected[:0], input), sum) == 1
+}
+
+// EncodeSum hex-encodes a sum.
+func EncodeSum(sum []byte) string {
+	return hex.EncodeToString(sum)
+}
+
+// DecodeSum decodes a hex-encoded sum.
+func DecodeSum(sumHex string) ([]byte, error) {
+	return hex.DecodeString(sumHex)
+}
+
+// EncodeInput base64-encodes an input, as sent to the plugin.
+func EncodeInput(input []byte) string {
+	return base64.StdEncoding.EncodeToString(input)
+}
+
+// DecodeInput decodes a base64-encoded input.
+func DecodeInput(inputB64 string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(inputB64)
+}
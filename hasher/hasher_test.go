@@ -0,0 +1,235 @@
+package hasher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+	"testing"
+	"testing/quick"
+)
+
+// concatenatedSum is the reference sum, computed over a fresh concatenation
+// of the salt and the input with a fresh hash function.
+func concatenatedSum(t testing.TB, algorithmName string, salt []byte, mode string, input []byte) []byte {
+	hf := algorithms[algorithmName].pool.New().(hash.Hash)
+
+	salted := make([]byte, 0, len(salt)+len(input))
+	if mode == ModePrepend {
+		salted = append(append(salted, salt...), input...)
+	} else {
+		salted = append(append(salted, input...), salt...)
+	}
+	if _, err := hf.Write(salted); err != nil {
+		t.Fatal(err)
+	}
+
+	return hf.Sum(nil)
+}
+
+func TestHasher(t *testing.T) {
+	// Test the sum documented in the README
+	h, err := NewFromBase64(SHA2256, "c2VjcmV0c2FsdA==", ModeAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := h.SumHex([]byte("secretdata")); sum != "675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98" {
+		t.Fatalf("bad sum: %s", sum)
+	}
+
+	// A salt with spare capacity, which prepending the input to must not
+	// write into.
+	salt := make([]byte, 8, 64)
+	copy(salt, "saltsalt")
+	spare := salt[:cap(salt)]
+	for i := len(salt); i < len(spare); i++ {
+		spare[i] = 0xAA
+	}
+	original := append([]byte(nil), spare...)
+
+	inputs := [][]byte{[]byte("first"), []byte("second input"), []byte("x")}
+	for _, algorithmName := range Algorithms() {
+		for _, mode := range Modes() {
+			h, err := New(algorithmName, salt, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Algorithm() != algorithmName || h.Mode() != mode {
+				t.Fatalf("bad hasher: %s %s", h.Algorithm(), h.Mode())
+			}
+
+			for _, input := range inputs {
+				expected := concatenatedSum(t, algorithmName, salt, mode, input)
+
+				got := h.Sum(input)
+				if !bytes.Equal(got, expected) {
+					t.Fatalf("%s %s: mismatched sums: %x != %x", algorithmName, mode, got, expected)
+				}
+				if len(got) != h.Size() {
+					t.Fatalf("%s: sum of %d bytes, expected %d", algorithmName, len(got), h.Size())
+				}
+				if h.SumHex(input) != EncodeSum(expected) {
+					t.Fatalf("%s %s: mismatched hex sums", algorithmName, mode)
+				}
+				if !h.Verify(input, expected) || h.Verify([]byte("other"), expected) {
+					t.Fatalf("%s %s: bad verification", algorithmName, mode)
+				}
+			}
+		}
+	}
+
+	if !bytes.Equal(salt[:cap(salt)], original) {
+		t.Fatal("salt modified by hashing")
+	}
+
+	// Test the salt is copied
+	h, err = New(SHA2256, salt, ModeAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := h.Sum(inputs[0])
+	salt[0] = 'x'
+	if !bytes.Equal(h.Sum(inputs[0]), expected) {
+		t.Fatal("hasher affected by changes to the salt")
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	if _, err := New("md5", nil, ModeAppend); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("expected unsupported algorithm error, got: %v", err)
+	}
+	if _, err := New(SHA2256, nil, "middle"); !errors.Is(err, ErrInvalidMode) {
+		t.Fatalf("expected invalid mode error, got: %v", err)
+	}
+	if _, err := NewFromBase64(SHA2256, "not base64!", ModeAppend); err == nil {
+		t.Fatal("expected error with invalid base64 salt")
+	}
+
+	if IsSupportedAlgorithm("md5") || !IsSupportedAlgorithm(SHA3512) {
+		t.Fatal("bad algorithm support")
+	}
+}
+
+// checkHasher is the property of the Hasher of the algorithm at index a of
+// Algorithms: its sums are the sums of a fresh concatenation of the salt and
+// the input, and the buffers of the caller are never written to, not even
+// their spare capacity.
+func checkHasher(t testing.TB, a uint8, salt []byte, prepend bool, input []byte, spare uint8) bool {
+	algorithmName := Algorithms()[int(a)%len(Algorithms())]
+	mode := ModeAppend
+	if prepend {
+		mode = ModePrepend
+	}
+
+	// Buffers with spare capacity, filled so writes past their length show
+	withSpare := func(b []byte) ([]byte, []byte) {
+		buf := make([]byte, len(b), len(b)+int(spare))
+		copy(buf, b)
+		full := buf[:cap(buf)]
+		for i := len(b); i < len(full); i++ {
+			full[i] = 0xAA
+		}
+		return buf, append([]byte(nil), full...)
+	}
+	salt, saltOriginal := withSpare(salt)
+	input, inputOriginal := withSpare(input)
+
+	h, err := New(algorithmName, salt, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := concatenatedSum(t, algorithmName, salt, mode, input)
+
+	dst := []byte("prefix")
+	got := h.AppendSum(dst, input)
+	if !bytes.Equal(got[:len(dst)], []byte("prefix")) || !bytes.Equal(got[len(dst):], expected) {
+		return false
+	}
+	if !bytes.Equal(h.Sum(input), expected) || !h.Verify(input, expected) {
+		return false
+	}
+
+	return bytes.Equal(salt[:cap(salt)], saltOriginal) && bytes.Equal(input[:cap(input)], inputOriginal)
+}
+
+func TestHasher_Properties(t *testing.T) {
+	property := func(a uint8, salt []byte, prepend bool, input []byte, spare uint8) bool {
+		return checkHasher(t, a, salt, prepend, input, spare)
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHasher_Concurrent(t *testing.T) {
+	salt := []byte("saltsalt")
+
+	hashers := make([]*Hasher, 0, len(algorithmNames))
+	for _, algorithmName := range Algorithms() {
+		h, err := New(algorithmName, salt, ModePrepend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashers = append(hashers, h)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for _, h := range hashers {
+				for j := 0; j < 50; j++ {
+					input := []byte(fmt.Sprintf("input-%d-%d", i, j))
+					got := h.Sum(input)
+					if expected := concatenatedSum(t, h.Algorithm(), salt, ModePrepend, input); !bytes.Equal(got, expected) {
+						t.Errorf("%s: mismatched sums: %x != %x", h.Algorithm(), got, expected)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkHasher(b *testing.B) {
+	salt := []byte("secretsalt")
+	input := []byte("secretdata")
+
+	b.Run("pooled", func(b *testing.B) {
+		h, err := New(SHA2256, salt, ModePrepend)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ReportAllocs()
+		var sum [MaxSize]byte
+		for i := 0; i < b.N; i++ {
+			h.AppendSum(sum[:0], input)
+		}
+	})
+
+	b.Run("concatenated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hf := sha256.New()
+			_, _ = hf.Write(append(append([]byte(nil), salt...), input...))
+			hf.Sum(nil)
+		}
+	})
+}
+
+func ExampleHasher() {
+	// The salt and mode of a role, as returned by its salt export
+	h, err := NewFromBase64(SHA2256, "c2VjcmV0c2FsdA==", ModeAppend)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(h.SumHex([]byte("secretdata")))
+	// Output: 675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98
+}
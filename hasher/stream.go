@@ -0,0 +1,114 @@
+package hasher
+
+import (
+	"encoding"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// IsStreamable reports whether the algorithm is supported and its state can
+// be marshaled, so its sums can be computed with a Stream.
+func IsStreamable(name string) bool {
+	a, ok := algorithms[name]
+	return ok && a.streamable
+}
+
+// StreamableAlgorithms returns the names of the streamable algorithms, in the
+// order of Algorithms.
+func StreamableAlgorithms() []string {
+	var names []string
+	for _, name := range algorithmNames {
+		if IsStreamable(name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// streamingUnsupported is the error of streaming with the named algorithm,
+// naming the streamable ones.
+func streamingUnsupported(name string) error {
+	return fmt.Errorf("%w %s, streamable algorithms are %s", ErrStreamingUnsupported, name, strings.Join(StreamableAlgorithms(), ", "))
+}
+
+// Stream computes the salted sum of an input written in chunks. Its state
+// can be marshaled between chunks and resumed with Hasher.ResumeStream, so
+// the chunks can be written by different processes.
+//
+// In prepend mode, the state is marshaled after the salt is written: anyone
+// holding it can compute the salted sums of any input, so it must be kept as
+// secret as the salt. A Stream isn't safe for concurrent use.
+type Stream struct {
+	h  *Hasher
+	hf hash.Hash
+}
+
+// NewStream returns a Stream of the salted sum of the Hasher, failing with
+// ErrStreamingUnsupported unless its algorithm IsStreamable.
+func (h *Hasher) NewStream() (*Stream, error) {
+	if !h.algorithm.streamable {
+		return nil, streamingUnsupported(h.name)
+	}
+
+	// Streams outlive requests, so their hash functions aren't pooled.
+	s := &Stream{h: h, hf: h.algorithm.newHash()}
+	if h.mode == ModePrepend {
+		_, _ = s.hf.Write(h.salt)
+	}
+
+	return s, nil
+}
+
+// ResumeStream returns the Stream of the state marshaled by
+// Stream.MarshalBinary, which must be of a Stream of the same algorithm,
+// salt and mode.
+func (h *Hasher) ResumeStream(state []byte) (*Stream, error) {
+	if !h.algorithm.streamable {
+		return nil, streamingUnsupported(h.name)
+	}
+
+	s := &Stream{h: h, hf: h.algorithm.newHash()}
+	if err := s.hf.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("invalid stream state: %w", err)
+	}
+
+	return s, nil
+}
+
+// Write writes a chunk of the input. It never fails.
+func (s *Stream) Write(p []byte) (int, error) {
+	return s.hf.Write(p)
+}
+
+// MarshalBinary returns the state of the Stream.
+func (s *Stream) MarshalBinary() ([]byte, error) {
+	return s.hf.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// Sum returns the salted sum of the input written so far. The Stream is left
+// as it was, so more chunks can still be written.
+func (s *Stream) Sum() []byte {
+	if s.h.mode == ModePrepend {
+		return s.hf.Sum(nil)
+	}
+
+	// The salt is written to a copy of the state, as the input may go on.
+	state, err := s.MarshalBinary()
+	if err != nil {
+		panic(fmt.Sprintf("hasher: unable to marshal the state of %s: %v", s.h.name, err))
+	}
+	hf := s.h.algorithm.newHash()
+	if err := hf.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		panic(fmt.Sprintf("hasher: unable to unmarshal the state of %s: %v", s.h.name, err))
+	}
+	_, _ = hf.Write(s.h.salt)
+
+	return hf.Sum(nil)
+}
+
+// SumHex returns Sum hex-encoded, as returned by the plugin.
+func (s *Stream) SumHex() string {
+	return EncodeSum(s.Sum())
+}
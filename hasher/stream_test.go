@@ -0,0 +1,110 @@
+package hasher
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+func TestStream(t *testing.T) {
+	salt := []byte("saltsalt")
+
+	for _, algorithmName := range Algorithms() {
+		for _, mode := range Modes() {
+			h, err := New(algorithmName, salt, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := h.NewStream()
+			if !IsStreamable(algorithmName) {
+				if !errors.Is(err, ErrStreamingUnsupported) {
+					t.Fatalf("%s: expected streaming unsupported error, got: %v", algorithmName, err)
+				}
+				if _, err := h.ResumeStream(nil); !errors.Is(err, ErrStreamingUnsupported) {
+					t.Fatalf("%s: expected streaming unsupported error resuming, got: %v", algorithmName, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Test sums can be taken midway, without altering the stream
+			_, _ = s.Write([]byte("secret"))
+			if s.SumHex() != h.SumHex([]byte("secret")) {
+				t.Fatalf("%s %s: mismatched midway sums", algorithmName, mode)
+			}
+			_, _ = s.Write([]byte("data"))
+			if s.SumHex() != h.SumHex([]byte("secretdata")) {
+				t.Fatalf("%s %s: mismatched sums", algorithmName, mode)
+			}
+
+			// Test invalid states and states of other algorithms are refused
+			state, err := s.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := h.ResumeStream([]byte("invalid")); err == nil {
+				t.Fatalf("%s: expected error resuming an invalid state", algorithmName)
+			}
+			for _, other := range Algorithms() {
+				if other == algorithmName || !IsStreamable(other) {
+					continue
+				}
+				otherHasher, _ := New(other, salt, mode)
+				if _, err := otherHasher.ResumeStream(state); err == nil {
+					t.Fatalf("%s: expected error resuming a state of %s", other, algorithmName)
+				}
+			}
+		}
+	}
+
+	if IsStreamable("md5") {
+		t.Fatal("unsupported algorithm streamable")
+	}
+	if names := StreamableAlgorithms(); !reflect.DeepEqual(names, []string{SHA1, SHA2256, SHA2512}) {
+		t.Fatalf("bad streamable algorithms: %v", names)
+	}
+}
+
+func TestStream_Properties(t *testing.T) {
+	// Test the sum of chunks written to streams resumed between chunks is
+	// the sum of the whole input
+	property := func(a uint8, salt []byte, prepend bool, chunks [][]byte) bool {
+		algorithmName := Algorithms()[int(a)%len(Algorithms())]
+		if !IsStreamable(algorithmName) {
+			return true
+		}
+		mode := ModeAppend
+		if prepend {
+			mode = ModePrepend
+		}
+		h, err := New(algorithmName, salt, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := h.NewStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, chunk := range chunks {
+			state, err := s.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s, err = h.ResumeStream(state); err != nil {
+				t.Fatal(err)
+			}
+			_, _ = s.Write(chunk)
+		}
+
+		return bytes.Equal(s.Sum(), concatenatedSum(t, algorithmName, salt, mode, bytes.Join(chunks, nil)))
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,100 @@
+package hasher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// VectorsVersion is the version of the vectors files read by ReadVectors.
+// Vectors are only ever added to a version: the sum of an existing vector
+// changing means the sums of existing roles change.
+const VectorsVersion = 1
+
+// EncodingHex is the encoding of the sums of vectors, as returned by the
+// plugin.
+const EncodingHex = "hex"
+
+// Vectors are known-answer test vectors of the salted sums, for checking
+// implementations of saltyhash against the plugin.
+type Vectors struct {
+	Version int      `json:"version"`
+	Vectors []Vector `json:"vectors"`
+}
+
+// Vector is the expected sum of an input salted with a salt in a mode.
+type Vector struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Mode      string `json:"mode"`
+
+	// Salt and Input are base64-encoded, as sent to the plugin.
+	Salt  string `json:"salt"`
+	Input string `json:"input"`
+
+	// Encoding is the encoding of Sum, EncodingHex.
+	Encoding string `json:"encoding"`
+	Sum      string `json:"sum"`
+}
+
+// ReadVectors reads a vectors file of VectorsVersion, checking every vector
+// is named uniquely and encoded as expected. Algorithms and modes aren't
+// checked, see Vector.Check.
+func ReadVectors(r io.Reader) (*Vectors, error) {
+	var v Vectors
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid vectors file: %w", err)
+	}
+	if v.Version != VectorsVersion {
+		return nil, fmt.Errorf("unsupported vectors version %d, expected %d", v.Version, VectorsVersion)
+	}
+	if len(v.Vectors) == 0 {
+		return nil, errors.New("no vectors")
+	}
+
+	names := make(map[string]bool, len(v.Vectors))
+	for i, vector := range v.Vectors {
+		if vector.Name == "" {
+			return nil, fmt.Errorf("vector %d: missing name", i)
+		}
+		if names[vector.Name] {
+			return nil, fmt.Errorf("vector %s: duplicate name", vector.Name)
+		}
+		names[vector.Name] = true
+
+		if vector.Encoding != EncodingHex {
+			return nil, fmt.Errorf("vector %s: unsupported encoding %q", vector.Name, vector.Encoding)
+		}
+		if _, err := DecodeInput(vector.Salt); err != nil {
+			return nil, fmt.Errorf("vector %s: invalid base64 salt: %w", vector.Name, err)
+		}
+		if _, err := DecodeInput(vector.Input); err != nil {
+			return nil, fmt.Errorf("vector %s: invalid base64 input: %w", vector.Name, err)
+		}
+		if _, err := DecodeSum(vector.Sum); err != nil {
+			return nil, fmt.Errorf("vector %s: invalid hex sum: %w", vector.Name, err)
+		}
+	}
+
+	return &v, nil
+}
+
+// Check computes the sum of the vector, returning an error unless it is the
+// expected one.
+func (v *Vector) Check() error {
+	h, err := NewFromBase64(v.Algorithm, v.Salt, v.Mode)
+	if err != nil {
+		return fmt.Errorf("vector %s: %w", v.Name, err)
+	}
+	input, err := DecodeInput(v.Input)
+	if err != nil {
+		return fmt.Errorf("vector %s: invalid base64 input: %w", v.Name, err)
+	}
+
+	if sum := h.SumHex(input); sum != v.Sum {
+		return fmt.Errorf("vector %s: sum %s, expected %s", v.Name, sum, v.Sum)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package hasher
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func readTestVectors(t testing.TB) *Vectors {
+	f, err := os.Open("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	v, err := ReadVectors(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestVectors(t *testing.T) {
+	v := readTestVectors(t)
+
+	covered := make(map[string]bool)
+	for _, vector := range v.Vectors {
+		if err := vector.Check(); err != nil {
+			t.Error(err)
+		}
+		covered[vector.Algorithm+"/"+vector.Mode] = true
+	}
+
+	// Test every algorithm is covered in every mode
+	for _, algorithm := range Algorithms() {
+		for _, mode := range Modes() {
+			if !covered[algorithm+"/"+mode] {
+				t.Errorf("no vectors of %s in %s mode", algorithm, mode)
+			}
+		}
+	}
+
+	// Test a wrong sum is reported
+	vector := v.Vectors[0]
+	vector.Sum = strings.Repeat("0", len(vector.Sum))
+	if err := vector.Check(); err == nil {
+		t.Fatal("expected error checking a wrong sum")
+	}
+}
+
+func TestReadVectors_Errors(t *testing.T) {
+	const valid = `{"name":"a","algorithm":"sha1","mode":"append","salt":"cw==","input":"YQ==","encoding":"hex","sum":"00"}`
+
+	for name, file := range map[string]string{
+		"invalid json":    `{"version":1,`,
+		"other version":   `{"version":2,"vectors":[` + valid + `]}`,
+		"no vectors":      `{"version":1,"vectors":[]}`,
+		"duplicate name":  `{"version":1,"vectors":[` + valid + `,` + valid + `]}`,
+		"missing name":    `{"version":1,"vectors":[` + strings.Replace(valid, `"a"`, `""`, 1) + `]}`,
+		"base64 sum":      `{"version":1,"vectors":[` + strings.Replace(valid, `"hex"`, `"base64"`, 1) + `]}`,
+		"invalid salt":    `{"version":1,"vectors":[` + strings.Replace(valid, `"cw=="`, `"cw"`, 1) + `]}`,
+		"invalid input":   `{"version":1,"vectors":[` + strings.Replace(valid, `"YQ=="`, `"!"`, 1) + `]}`,
+		"invalid hex sum": `{"version":1,"vectors":[` + strings.Replace(valid, `"00"`, `"0g"`, 1) + `]}`,
+	} {
+		if _, err := ReadVectors(strings.NewReader(file)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
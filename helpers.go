@@ -1,14 +1,12 @@
 package saltyhash
 
 import (
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
-	"hash"
 
 	"github.com/hashicorp/vault/sdk/framework"
-	"golang.org/x/crypto/sha3"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
 )
 
 func validateFieldSet(data *framework.FieldData) error {
@@ -21,33 +19,36 @@ func validateFieldSet(data *framework.FieldData) error {
 	return nil
 }
 
-func hashFunction(algorithm string) (hash.Hash, error) {
-	var hf hash.Hash
-	switch algorithm {
-	case "sha1":
-		hf = sha1.New()
-	case "sha2-256":
-		hf = sha256.New()
-	case "sha2-512":
-		hf = sha512.New()
-	case "sha3-256":
-		hf = sha3.New256()
-	case "sha3-512":
-		hf = sha3.New512()
-	default:
-		return nil, fmt.Errorf("unsupported algorithm %s", algorithm)
+// allowedAlgorithms and allowedSaltModes are the values of the algorithm and
+// mode fields, as documented in the field schemas. allowedStreamAlgorithms are
+// the values of the algorithm of hash_stream.
+var (
+	allowedAlgorithms       = allowedValues(hasher.Algorithms())
+	allowedStreamAlgorithms = allowedValues(hasher.StreamableAlgorithms())
+	allowedSaltModes        = allowedValues(hasher.Modes())
+)
+
+func allowedValues(values []string) []interface{} {
+	allowed := make([]interface{}, len(values))
+	for i, v := range values {
+		allowed[i] = v
 	}
 
-	return hf, nil
+	return allowed
 }
 
-func saltSecret(secret []byte, salt []byte, mode string) []byte {
-	switch mode {
-	case "append":
-		secret = append(secret, salt...)
-	case "prepend":
-		secret = append(salt, secret...)
+// saltLength is the size in bytes of the salts generated by the backend.
+const saltLength = 32
+
+func generateSalt() (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
 	}
 
-	return secret
+	return base64.StdEncoding.EncodeToString(salt), nil
+}
+
+func isValidSaltMode(mode string) bool {
+	return hasher.IsValidMode(mode)
 }
@@ -0,0 +1,242 @@
+package saltyhash
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+// concatenatedSum is the reference sha2-256 sum, computed over a fresh
+// concatenation of the salt and the input.
+func concatenatedSum(salt []byte, mode string, input []byte) []byte {
+	salted := make([]byte, 0, len(salt)+len(input))
+	if mode == "prepend" {
+		salted = append(append(salted, salt...), input...)
+	} else {
+		salted = append(append(salted, input...), salt...)
+	}
+
+	sum := sha256.Sum256(salted)
+	return sum[:]
+}
+
+// createModeRoles creates a role with testSalt in each salt mode, named
+// after the mode.
+func createModeRoles(t testing.TB, b *backend, storage logical.Storage) {
+	for _, mode := range hasher.Modes() {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "roles/" + mode,
+			Data: map[string]interface{}{
+				"salt": testSalt,
+				"mode": mode,
+			},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+	}
+}
+
+// modeRoleHasher returns the role of createModeRoles in prepend mode or not,
+// and its hasher of the algorithm at index a of the supported algorithms.
+func modeRoleHasher(t testing.TB, a uint8, prepend bool) (string, *hasher.Hasher) {
+	role := hasher.ModeAppend
+	if prepend {
+		role = hasher.ModePrepend
+	}
+
+	h, err := hasher.NewFromBase64(hasher.Algorithms()[int(a)%len(hasher.Algorithms())], testSalt, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return role, h
+}
+
+// checkHashRequests hashes each input alone and all of them in a batch with
+// the role, checking invalid inputs get error responses, valid ones the sums
+// of the hasher, and item N of hash_batch is the sum hash returns for input N.
+// Batches are also invalid if an input has surrounding whitespace, as the
+// framework would trim it.
+func checkHashRequests(t testing.TB, b *backend, storage logical.Storage, role string, h *hasher.Hasher, inputs []string) {
+	handle := func(path string, input interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path + "/" + role + "/" + h.Algorithm(),
+			Data:      map[string]interface{}{"input": input},
+		})
+	}
+
+	valid := true
+	sums := make([]string, len(inputs))
+	for i, inputB64 := range inputs {
+		if inputB64 != strings.TrimSpace(inputB64) {
+			valid = false
+		}
+		resp, err := handle("hash", inputB64)
+
+		input, decodeErr := base64.StdEncoding.DecodeString(inputB64)
+		if len(input) == 0 || decodeErr != nil {
+			valid = false
+			if err != logical.ErrInvalidRequest || !resp.IsError() {
+				t.Fatalf("%q: expected error response, got: %#v, err: %v", inputB64, resp, err)
+			}
+			continue
+		}
+
+		if err != nil || resp.IsError() {
+			t.Fatalf("%q: bad: resp: %#v, err: %v", inputB64, resp, err)
+		}
+		if sums[i] = resp.Data["sum"].(string); sums[i] != h.SumHex(input) {
+			t.Fatalf("%q: mismatched sums: %s != %s", inputB64, sums[i], h.SumHex(input))
+		}
+	}
+
+	resp, err := handle("hash_batch", inputs)
+	if !valid {
+		if err != logical.ErrInvalidRequest || !resp.IsError() {
+			t.Fatalf("%q: expected batch error response, got: %#v, err: %v", inputs, resp, err)
+		}
+		return
+	}
+	if err != nil || resp.IsError() {
+		t.Fatalf("%q: bad batch: resp: %#v, err: %v", inputs, resp, err)
+	}
+	batchSums := resp.Data["sums"].([]string)
+	if len(batchSums) != len(sums) {
+		t.Fatalf("%q: %d batch sums, expected %d", inputs, len(batchSums), len(sums))
+	}
+	for i := range sums {
+		if batchSums[i] != sums[i] {
+			t.Fatalf("%q: mismatched batch sum %d: %s != %s", inputs, i, batchSums[i], sums[i])
+		}
+	}
+}
+
+func TestSalty_HashConcurrent(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt": testSalt,
+			"mode": "prepend",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	salt, _ := base64.StdEncoding.DecodeString(testSalt)
+	input := func(i int) []byte {
+		return []byte(fmt.Sprintf("secret-%d", i))
+	}
+	expected := func(i int) string {
+		return hex.EncodeToString(concatenatedSum(salt, "prepend", input(i)))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      hashPath + "/sha2-256",
+				Data:      map[string]interface{}{"input": base64.StdEncoding.EncodeToString(input(i))},
+			})
+			if err != nil || resp.IsError() {
+				t.Errorf("bad: resp: %#v, err: %v", resp, err)
+				return
+			}
+			if resp.Data["sum"] != expected(i) {
+				t.Errorf("mismatched sums: %s != %s", resp.Data["sum"], expected(i))
+			}
+		}(i)
+
+		go func(i int) {
+			defer wg.Done()
+
+			// Inputs of decreasing length, so the decoding buffer is reused
+			// with stale bytes past the current input.
+			batch := []string{
+				base64.StdEncoding.EncodeToString(append(input(i), "-longer"...)),
+				base64.StdEncoding.EncodeToString(input(i)),
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      hashBatchPath + "/sha2-256",
+				Data:      map[string]interface{}{"input": batch},
+			})
+			if err != nil || resp.IsError() {
+				t.Errorf("bad: resp: %#v, err: %v", resp, err)
+				return
+			}
+			if sums := resp.Data["sums"].([]string); sums[1] != expected(i) {
+				t.Errorf("mismatched batch sums: %s != %s", sums[1], expected(i))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func benchmarkHashRequest(b *testing.B, path string, input interface{}) {
+	backend, storage := createBackendWithStorage(b)
+
+	_, err := backend.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt": testSalt,
+			"mode": "prepend",
+		},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := backend.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      map[string]interface{}{"input": input},
+		})
+		if err != nil || resp.IsError() {
+			b.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	benchmarkHashRequest(b, hashPath+"/sha2-256", testSecret)
+}
+
+func BenchmarkHashBatch(b *testing.B) {
+	batch := make([]string, 100)
+	for i := range batch {
+		batch[i] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("secret-%d", i)))
+	}
+
+	benchmarkHashRequest(b, hashBatchPath+"/sha2-256", batch)
+}
@@ -0,0 +1,406 @@
+package saltyhash
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/testhelpers"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/helper/pluginutil"
+	"github.com/hashicorp/vault/sdk/physical"
+	"github.com/hashicorp/vault/sdk/physical/inmem"
+	"github.com/hashicorp/vault/sdk/plugin"
+	"github.com/hashicorp/vault/vault"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/client"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+const (
+	testPluginName = "saltyhash"
+
+	testPolicyHash = `
+path "saltyhash/hash/test/*" {
+	capabilities = ["update"]
+}`
+
+	testPolicyExport = `
+path "saltyhash/roles/+/export_salt" {
+	capabilities = ["update"]
+}`
+)
+
+// TestIntegration_PluginMain serves the backend when the test binary is run
+// by Vault as the plugin of newTestCluster, and does nothing otherwise.
+func TestIntegration_PluginMain(t *testing.T) {
+	if os.Getenv(pluginutil.PluginVaultVersionEnv) == "" {
+		return
+	}
+
+	apiClientMeta := &api.PluginAPIClientMeta{}
+	flags := apiClientMeta.FlagSet()
+	_ = flags.Parse([]string{"--ca-cert=" + os.Getenv(pluginutil.PluginCACertPEMEnv)})
+
+	if err := plugin.Serve(&plugin.ServeOpts{
+		BackendFactoryFunc: Factory,
+		TLSProviderFunc:    api.VaultPluginTLSProvider(apiClientMeta.GetTLSConfig()),
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestCluster starts an in-process HA cluster of Vault cores, registers
+// the test binary as the saltyhash plugin and mounts it at saltyhash/,
+// returning the cluster and a root client of its active core.
+func newTestCluster(t *testing.T) (*vault.TestCluster, *api.Client) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	logger := logging.NewVaultLogger(hclog.Error)
+	physicalBackend, err := inmem.NewTransactionalInmem(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	haBackend, err := inmem.NewInmemHA(nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every core runs the plugin from the directory of the test binary,
+	// so any of them can become active.
+	pluginDir, err := filepath.EvalSymlinks(filepath.Dir(os.Args[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := vault.NewTestCluster(t, &vault.CoreConfig{
+		Physical:        physicalBackend,
+		HAPhysical:      haBackend.(physical.HABackend),
+		PluginDirectory: pluginDir,
+	}, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+		Logger:      logger,
+	})
+	cluster.Start()
+	t.Cleanup(cluster.Cleanup)
+
+	// The plugin process reads the CA of the cluster from its environment,
+	// as in the plugin tests of Vault. The previous value is restored for
+	// the tests run after this one.
+	previousCACert, hadCACert := os.LookupEnv(pluginutil.PluginCACertPEMEnv)
+	t.Cleanup(func() {
+		if hadCACert {
+			os.Setenv(pluginutil.PluginCACertPEMEnv, previousCACert)
+		} else {
+			os.Unsetenv(pluginutil.PluginCACertPEMEnv)
+		}
+	})
+	os.Setenv(pluginutil.PluginCACertPEMEnv, cluster.CACertPEMFile)
+
+	active := testhelpers.WaitForActiveNode(t, cluster)
+	testhelpers.WaitForNCoresUnsealed(t, cluster, len(cluster.Cores))
+
+	sum, err := fileSHA256(filepath.Join(pluginDir, filepath.Base(os.Args[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := active.Client.Sys().RegisterPlugin(&api.RegisterPluginInput{
+		Name:    testPluginName,
+		Type:    consts.PluginTypeSecrets,
+		Command: filepath.Base(os.Args[0]),
+		Args:    []string{"--test.run=TestIntegration_PluginMain"},
+		SHA256:  sum,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := active.Client.Sys().Mount("saltyhash", &api.MountInput{Type: testPluginName}); err != nil {
+		t.Fatal(err)
+	}
+
+	return cluster, active.Client
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// tokenWithPolicy creates the policy and returns a client authenticated with
+// a token of only that policy, next to the default one.
+func tokenWithPolicy(t *testing.T, root *api.Client, name, policy string) *api.Client {
+	if err := root.Sys().PutPolicy(name, policy); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := root.Auth().Token().Create(&api.TokenCreateRequest{Policies: []string{name}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := root.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetToken(secret.Auth.ClientToken)
+
+	return c
+}
+
+func isStatus(err error, status int) bool {
+	respErr, ok := err.(*api.ResponseError)
+	return ok && respErr.StatusCode == status
+}
+
+func TestIntegration_Policies(t *testing.T) {
+	_, root := newTestCluster(t)
+	ctx := context.Background()
+
+	admin := client.New(root, "saltyhash")
+	for _, name := range []string{"test", "other"} {
+		if err := admin.WriteRole(ctx, name, &client.Role{Salt: "dGVzdFNhbHQ=", Mode: hasher.ModeAppend}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := hasher.NewFromBase64(hasher.SHA2256, "dGVzdFNhbHQ=", hasher.ModeAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test a token allowed to hash with a role only hashes with that role
+	c := client.New(tokenWithPolicy(t, root, "hash-test", testPolicyHash), "saltyhash")
+	sum, err := c.Hash(ctx, "test", hasher.SHA2256, []byte("secretdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Sum != h.SumHex([]byte("secretdata")) || sum.SaltVersion != 1 {
+		t.Fatalf("bad sum: %#v", sum)
+	}
+
+	if _, err := c.Hash(ctx, "other", hasher.SHA2256, []byte("secretdata")); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected permission denied hashing with another role, got: %v", err)
+	}
+	if _, err := c.HashBatch(ctx, "test", hasher.SHA2256, [][]byte{[]byte("secretdata")}); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected permission denied batch hashing, got: %v", err)
+	}
+	if _, err := c.ReadRole(ctx, "test"); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected permission denied reading the role, got: %v", err)
+	}
+	if err := c.WriteRole(ctx, "test", &client.Role{Salt: "b3RoZXI=", Mode: hasher.ModeAppend}); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected permission denied updating the role, got: %v", err)
+	}
+	if _, err := c.RotateRole(ctx, "test"); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected permission denied rotating the role, got: %v", err)
+	}
+
+	// Test the batch size of the mount is discovered with the default
+	// size when the config isn't readable
+	if size := c.BatchSize(ctx); size != client.DefaultBatchSize {
+		t.Fatalf("expected default batch size, got %d", size)
+	}
+}
+
+func TestIntegration_ResponseWrapping(t *testing.T) {
+	_, root := newTestCluster(t)
+	ctx := context.Background()
+
+	admin := client.New(root, "saltyhash")
+	if err := admin.WriteRole(ctx, "test", &client.Role{Salt: "dGVzdFNhbHQ=", Mode: hasher.ModePrepend, Exportable: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test salt exports are wrapped by Vault even if the client didn't ask
+	exporter := tokenWithPolicy(t, root, "export", testPolicyExport)
+	secret, err := exporter.Logical().Write("saltyhash/roles/test/export_salt", map[string]interface{}{"wrap_ttl": 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.WrapInfo == nil || secret.WrapInfo.Token == "" || secret.Data != nil {
+		t.Fatalf("export not wrapped: %#v", secret)
+	}
+	if secret.WrapInfo.TTL != 120 || secret.WrapInfo.CreationPath != "saltyhash/roles/test/export_salt" {
+		t.Fatalf("bad wrap info: %#v", secret.WrapInfo)
+	}
+
+	// Test the wrapping token is looked up and unwrapped once, by a token
+	// without access to the mount
+	lookup, err := exporter.Logical().Write("sys/wrapping/lookup", map[string]interface{}{"token": secret.WrapInfo.Token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup.Data["creation_path"] != "saltyhash/roles/test/export_salt" {
+		t.Fatalf("bad lookup: %#v", lookup.Data)
+	}
+
+	unwrapper := tokenWithPolicy(t, root, "nothing", `path "secret/*" { capabilities = ["read"] }`)
+	unwrapped, err := unwrapper.Logical().Unwrap(secret.WrapInfo.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped.Data["salt"] != "dGVzdFNhbHQ=" || unwrapped.Data["mode"] != hasher.ModePrepend {
+		t.Fatalf("bad unwrapped export: %#v", unwrapped.Data)
+	}
+	if _, err := unwrapper.Logical().Unwrap(secret.WrapInfo.Token); err == nil {
+		t.Fatal("expected error unwrapping twice")
+	}
+
+	// Test the unwrapped salt computes the sums of Vault offline
+	h, err := hasher.NewFromBase64(hasher.SHA3256, unwrapped.Data["salt"].(string), unwrapped.Data["mode"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := admin.Hash(ctx, "test", hasher.SHA3256, []byte("secretdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Sum != h.SumHex([]byte("secretdata")) {
+		t.Fatalf("offline sum %s doesn't match %s", h.SumHex([]byte("secretdata")), sum.Sum)
+	}
+
+	// Test the export is recorded with the display name of the token
+	role, err := root.Logical().Read("saltyhash/roles/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports, ok := role.Data["salt_exports"].([]interface{})
+	if !ok || len(exports) != 1 || exports[0].(map[string]interface{})["display_name"] != "token" {
+		t.Fatalf("bad salt exports: %#v", role.Data["salt_exports"])
+	}
+
+	// Test clients can ask for any response to be wrapped
+	wrapping, err := root.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapping.SetToken(root.Token())
+	wrapping.SetWrappingLookupFunc(func(string, string) string { return "5m" })
+	secret, err = wrapping.Logical().Write("saltyhash/hash/test/sha3-256", map[string]interface{}{"input": "c2VjcmV0ZGF0YQ=="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.WrapInfo == nil || secret.WrapInfo.TTL != 300 {
+		t.Fatalf("hash not wrapped: %#v", secret)
+	}
+	unwrapped, err = root.Logical().Unwrap(secret.WrapInfo.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped.Data["sum"] != sum.Sum {
+		t.Fatalf("bad unwrapped sum: %#v", unwrapped.Data)
+	}
+}
+
+// TestIntegration_HA covers the behavior replication relies on as far as an
+// open source cluster has it: standbys forward to the active core, local
+// mounts, and a new active core taking over the mount from storage.
+func TestIntegration_HA(t *testing.T) {
+	cluster, root := newTestCluster(t)
+	ctx := context.Background()
+
+	var standby *vault.TestClusterCore
+	for _, core := range cluster.Cores {
+		if core.Client.Address() != root.Address() {
+			standby = core
+			break
+		}
+	}
+
+	// Test requests to a standby are forwarded to the active core
+	standbyClient, err := standby.Client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	standbyClient.SetToken(root.Token())
+	c := client.New(standbyClient, "saltyhash")
+	if err := c.WriteRole(ctx, "test", &client.Role{Salt: "dGVzdFNhbHQ=", Mode: hasher.ModeAppend}); err != nil {
+		t.Fatal(err)
+	}
+	version, err := c.RotateRole(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Fatalf("expected salt version 2, got %d", version)
+	}
+	before, err := c.Hash(ctx, "test", hasher.SHA2512, []byte("secretdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.SaltVersion != 2 {
+		t.Fatalf("bad sum: %#v", before)
+	}
+
+	// Test local mounts serve the plugin as well
+	if err := root.Sys().Mount("saltyhash-local", &api.MountInput{Type: testPluginName, Local: true}); err != nil {
+		t.Fatal(err)
+	}
+	local := client.New(root, "saltyhash-local")
+	if err := local.WriteRole(ctx, "test", &client.Role{Salt: "dGVzdFNhbHQ=", Mode: hasher.ModeAppend}); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := local.Hash(ctx, "test", hasher.SHA2512, []byte("secretdata")); err != nil || sum.SaltVersion != 1 {
+		t.Fatalf("bad local sum: %#v, err: %v", sum, err)
+	}
+
+	// Test a new active core serves the roles of the previous one, with the
+	// salts it encrypted
+	if err := root.Sys().StepDown(); err != nil {
+		t.Fatal(err)
+	}
+	var active *vault.TestClusterCore
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		active = testhelpers.WaitForActiveNode(t, cluster)
+		if active.Client.Address() != root.Address() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no other core became active")
+		}
+	}
+
+	activeClient, err := active.Client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeClient.SetToken(root.Token())
+	c = client.New(activeClient, "saltyhash")
+
+	role, err := c.ReadRole(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.SaltVersion != 2 {
+		t.Fatalf("bad role after failover: %#v", role)
+	}
+	after, err := c.Hash(ctx, "test", hasher.SHA2512, []byte("secretdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *after != *before {
+		t.Fatalf("sum changed after failover: %#v, expected %#v", after, before)
+	}
+	ok, err := c.Verify(ctx, "test", hasher.SHA2512, before.SaltVersion, []byte("secretdata"), before.Sum)
+	if err != nil || !ok {
+		t.Fatalf("sum not verified after failover: %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+// Package devserver serves a mount of the backend behind a minimal HTTP
+// server mimicking the Vault API, for the dev command and the tests of the
+// clients of the API.
+package devserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/base62"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	saltyhash "github.com/unflag/vault-plugin-secrets-saltyhash"
+)
+
+// Handler serves the API of a mount of the backend like Vault does.
+type Handler struct {
+	backend logical.Backend
+	storage logical.Storage
+	mount   string
+	token   string
+	logger  hclog.Logger
+
+	// wrapped are the wrapped responses by wrapping token.
+	wrappedLock sync.Mutex
+	wrapped     map[string]*wrappedResponse
+}
+
+type wrappedResponse struct {
+	data    map[string]interface{}
+	expires time.Time
+}
+
+// New returns the handler of a mount of the backend at the mount path,
+// accepting the token and keeping its data in the storage.
+func New(ctx context.Context, mount, token string, storage logical.Storage, logger hclog.Logger) (*Handler, error) {
+	config := logical.TestBackendConfig()
+	config.StorageView = storage
+	config.Logger = logger
+	config.BackendUUID = "dev"
+
+	b, err := saltyhash.Factory(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+		return nil, err
+	}
+
+	return &Handler{
+		backend: b,
+		storage: storage,
+		mount:   strings.Trim(mount, "/"),
+		token:   token,
+		logger:  logger,
+		wrapped: make(map[string]*wrappedResponse),
+	}, nil
+}
+
+// RunPeriodic runs the periodic function of the backend every period, like
+// the rollback manager of Vault.
+func (h *Handler) RunPeriodic(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, err := h.backend.HandleRequest(ctx, &logical.Request{
+				Operation: logical.RollbackOperation,
+				Storage:   h.storage,
+			})
+			if err != nil {
+				h.logger.Error("periodic function failed", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/sys/health" {
+		h.respond(w, http.StatusOK, map[string]interface{}{"initialized": true, "sealed": false, "standby": false})
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if bearer := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(bearer, "Bearer ") {
+		token = strings.TrimPrefix(bearer, "Bearer ")
+	}
+
+	if r.URL.Path == "/v1/sys/wrapping/unwrap" {
+		h.unwrap(w, r, token)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		h.respondError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	reqPath, ok := h.mountPath(r.URL.Path)
+	if !ok {
+		h.respondError(w, http.StatusNotFound, fmt.Sprintf("no handler for route %q", r.URL.Path))
+		return
+	}
+
+	req, err := h.newRequest(r, reqPath)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := h.backend.HandleRequest(r.Context(), req)
+	if status, err := logical.RespondErrorCommon(req, resp, err); status != 0 {
+		var errs []string
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		h.respond(w, status, map[string]interface{}{"errors": errs})
+		return
+	}
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Responses are wrapped if the backend or the client asks for it.
+	wrapTTL := time.Duration(0)
+	if resp.WrapInfo != nil {
+		wrapTTL = resp.WrapInfo.TTL
+	}
+	if wrapTTL == 0 && r.Header.Get("X-Vault-Wrap-TTL") != "" {
+		if wrapTTL, err = parseDuration(r.Header.Get("X-Vault-Wrap-TTL")); err != nil {
+			h.respondError(w, http.StatusBadRequest, "invalid wrap TTL: "+err.Error())
+			return
+		}
+	}
+	if wrapTTL > 0 {
+		wrapInfo, err := h.wrap(resp.Data, wrapTTL, req.Path)
+		if err != nil {
+			h.respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		h.respondSecret(w, req.ID, nil, wrapInfo, resp.Warnings)
+		return
+	}
+
+	h.respondSecret(w, req.ID, resp.Data, nil, resp.Warnings)
+}
+
+// mountPath returns the path in the mount of the cleaned URL path, keeping
+// its trailing slash, and whether the URL path is in the mount at all.
+func (h *Handler) mountPath(urlPath string) (string, bool) {
+	mountPrefix := "/v1/" + h.mount
+	cleaned := path.Clean("/" + urlPath)
+	if cleaned != mountPrefix && !strings.HasPrefix(cleaned, mountPrefix+"/") {
+		return "", false
+	}
+
+	reqPath := strings.TrimPrefix(strings.TrimPrefix(cleaned, mountPrefix), "/")
+	if reqPath != "" && strings.HasSuffix(urlPath, "/") {
+		reqPath += "/"
+	}
+
+	return reqPath, true
+}
+
+// newRequest returns the backend request of the HTTP request to the path of
+// the mount. Writes to missing entries are routed to create as in Vault.
+func (h *Handler) newRequest(r *http.Request, path string) (*logical.Request, error) {
+	id, err := base62.Random(20)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token is never passed on to the backend, as in Vault.
+	headers := r.Header.Clone()
+	headers.Del("X-Vault-Token")
+	headers.Del("Authorization")
+
+	req := &logical.Request{
+		ID:          id,
+		Path:        path,
+		Storage:     h.storage,
+		MountPoint:  h.mount + "/",
+		DisplayName: "dev",
+		Connection:  &logical.Connection{RemoteAddr: r.RemoteAddr},
+		Headers:     headers,
+	}
+
+	switch {
+	case r.Method == "LIST" || (r.Method == http.MethodGet && r.URL.Query().Get("list") == "true"):
+		req.Operation = logical.ListOperation
+	case r.Method == http.MethodGet:
+		req.Operation = logical.ReadOperation
+	case r.Method == http.MethodDelete:
+		req.Operation = logical.DeleteOperation
+	case r.Method == http.MethodPost || r.Method == http.MethodPut:
+		req.Operation = logical.UpdateOperation
+	default:
+		return nil, fmt.Errorf("unsupported method %s", r.Method)
+	}
+
+	if req.Operation == logical.UpdateOperation {
+		if err := jsonutil.DecodeJSONFromReader(r.Body, &req.Data); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to parse JSON input: %w", err)
+		}
+
+		checkFound, exists, err := h.backend.HandleExistenceCheck(r.Context(), req)
+		if err != nil {
+			return nil, err
+		}
+		if checkFound && !exists {
+			req.Operation = logical.CreateOperation
+		}
+	} else {
+		// Query parameters are the data of reads, as in Vault.
+		req.Data = make(map[string]interface{})
+		for k, v := range r.URL.Query() {
+			switch {
+			case k == "list":
+			case len(v) == 1:
+				req.Data[k] = v[0]
+			default:
+				req.Data[k] = v
+			}
+		}
+	}
+
+	return req, nil
+}
+
+// wrap stores the data of a response for a single unwrap within the TTL
+// and returns the wrap info of the response.
+func (h *Handler) wrap(data map[string]interface{}, ttl time.Duration, path string) (map[string]interface{}, error) {
+	token, err := base62.Random(24)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	h.wrappedLock.Lock()
+	defer h.wrappedLock.Unlock()
+
+	for token, wrapped := range h.wrapped {
+		if now.After(wrapped.expires) {
+			delete(h.wrapped, token)
+		}
+	}
+	h.wrapped[token] = &wrappedResponse{data: data, expires: now.Add(ttl)}
+
+	return map[string]interface{}{
+		"token":         "s." + token,
+		"accessor":      "",
+		"ttl":           int(ttl.Seconds()),
+		"creation_time": now,
+		"creation_path": h.mount + "/" + path,
+	}, nil
+}
+
+// unwrap responds with the wrapped response of the wrapping token, given
+// either as the request token or in the body, and forgets it.
+func (h *Handler) unwrap(w http.ResponseWriter, r *http.Request, token string) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := jsonutil.DecodeJSONFromReader(r.Body, &body); err != nil && err != io.EOF {
+		h.respondError(w, http.StatusBadRequest, "failed to parse JSON input: "+err.Error())
+		return
+	}
+	if body.Token != "" {
+		token = body.Token
+	}
+
+	h.wrappedLock.Lock()
+	wrapped := h.wrapped[strings.TrimPrefix(token, "s.")]
+	delete(h.wrapped, strings.TrimPrefix(token, "s."))
+	h.wrappedLock.Unlock()
+
+	if wrapped == nil || time.Now().After(wrapped.expires) {
+		h.respondError(w, http.StatusBadRequest, "wrapping token is not valid or does not exist")
+		return
+	}
+
+	h.respondSecret(w, "", wrapped.data, nil, nil)
+}
+
+// respondSecret responds with the JSON envelope of Vault.
+func (h *Handler) respondSecret(w http.ResponseWriter, requestID string, data, wrapInfo map[string]interface{}, warnings []string) {
+	var wrap interface{}
+	if wrapInfo != nil {
+		wrap = wrapInfo
+	}
+
+	h.respond(w, http.StatusOK, map[string]interface{}{
+		"request_id":     requestID,
+		"lease_id":       "",
+		"renewable":      false,
+		"lease_duration": 0,
+		"data":           data,
+		"wrap_info":      wrap,
+		"warnings":       warnings,
+		"auth":           nil,
+	})
+}
+
+func (h *Handler) respondError(w http.ResponseWriter, status int, err string) {
+	h.respond(w, status, map[string]interface{}{"errors": []string{err}})
+}
+
+func (h *Handler) respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.logger.Warn("failed to write response", "error", err)
+	}
+}
+
+// parseDuration parses a duration in seconds or with a unit, as the wrap
+// TTL header of Vault.
+func parseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("negative duration")
+	}
+
+	return d, nil
+}
@@ -0,0 +1,215 @@
+package devserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/hashicorp/vault/sdk/physical/file"
+)
+
+const (
+	testSalt = "c2VjcmV0c2FsdA=="
+
+	// The sums of "secretdata" and "other" salted with "secretsalt" appended.
+	testSum      = "675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98"
+	testOtherSum = "6ee96e4ef6448cc53d066c1a1d2071ecca4be356b74dd4bd1a743e03450930a7"
+)
+
+func newDevServer(t *testing.T, storage logical.Storage) (*Handler, *api.Client) {
+	handler, err := New(context.Background(), "saltyhash", "root", storage, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	vault, err := api.NewClient(&api.Config{Address: server.URL, MaxRetries: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.SetToken("root")
+
+	return handler, vault
+}
+
+func TestDevServer(t *testing.T) {
+	_, vault := newDevServer(t, &logical.InmemStorage{})
+
+	// Test the token is required
+	unauthenticated, err := vault.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unauthenticated.SetToken("other")
+	if _, err := unauthenticated.Logical().Read("saltyhash/config"); err == nil {
+		t.Fatal("expected error with an invalid token")
+	}
+
+	// Test other mounts aren't served
+	if secret, err := vault.Logical().Read("secret/data/test"); err != nil || secret != nil {
+		t.Fatalf("expected not found outside of the mount: %#v, err: %v", secret, err)
+	}
+
+	// Test roles are created through create, as in Vault
+	if _, err := vault.Logical().Write("saltyhash/roles/test", map[string]interface{}{"mode": "append"}); err == nil {
+		t.Fatal("expected error creating a role without salt")
+	}
+	if _, err := vault.Logical().Write("saltyhash/roles/test", map[string]interface{}{
+		"salt":       testSalt,
+		"mode":       "append",
+		"exportable": true,
+		"metadata":   "team=billing",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := vault.Logical().Write("saltyhash/hash/test/sha2-256", map[string]interface{}{"input": "c2VjcmV0ZGF0YQ=="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["sum"] != testSum || secret.RequestID == "" {
+		t.Fatalf("bad hash response: %#v", secret)
+	}
+
+	secret, err = vault.Logical().Write("saltyhash/hash_batch/test/sha2-256", map[string]interface{}{
+		"input": []string{"c2VjcmV0ZGF0YQ==", "b3RoZXI="},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sums := secret.Data["sums"].([]interface{}); len(sums) != 2 || sums[0] != testSum || sums[1] != testOtherSum {
+		t.Fatalf("bad hash_batch response: %#v", secret.Data)
+	}
+
+	// Test lists, filtered through query parameters
+	secret, err = vault.Logical().List("saltyhash/roles")
+	if err != nil || secret == nil {
+		t.Fatalf("bad list: %#v, err: %v", secret, err)
+	}
+	if keys := secret.Data["keys"].([]interface{}); len(keys) != 1 || keys[0] != "test" {
+		t.Fatalf("bad list: %#v", secret.Data)
+	}
+	secret, err = vault.Logical().ReadWithData("saltyhash/roles", map[string][]string{"list": {"true"}, "metadata": {"team=other"}})
+	if err != nil || secret != nil {
+		t.Fatalf("expected empty filtered list: %#v, err: %v", secret, err)
+	}
+
+	// Test salt exports are wrapped and unwrapped once
+	secret, err = vault.Logical().Write("saltyhash/roles/test/export_salt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.WrapInfo == nil || secret.WrapInfo.Token == "" || secret.Data != nil {
+		t.Fatalf("export not wrapped: %#v", secret)
+	}
+	unwrapped, err := vault.Logical().Unwrap(secret.WrapInfo.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped.Data["salt"] != testSalt || unwrapped.Data["mode"] != "append" {
+		t.Fatalf("bad unwrapped export: %#v", unwrapped.Data)
+	}
+	if _, err := vault.Logical().Unwrap(secret.WrapInfo.Token); err == nil {
+		t.Fatal("expected error unwrapping twice")
+	}
+
+	// Test clients can ask for wrapping
+	wrapping, err := vault.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapping.SetToken("root")
+	wrapping.SetWrappingLookupFunc(func(string, string) string { return "5m" })
+	secret, err = wrapping.Logical().Read("saltyhash/roles/test")
+	if err != nil || secret.WrapInfo == nil || secret.WrapInfo.TTL != 300 {
+		t.Fatalf("read not wrapped: %#v, err: %v", secret, err)
+	}
+
+	// Test deletes
+	if _, err := vault.Logical().Delete("saltyhash/roles/test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Logical().Write("saltyhash/hash/test/sha2-256", map[string]interface{}{"input": "c2VjcmV0ZGF0YQ=="}); err == nil {
+		t.Fatal("expected error hashing with a deleted role")
+	}
+
+	resp, err := http.Get(vault.Address() + "/v1/sys/health")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad health: %v, err: %v", resp, err)
+	}
+	resp.Body.Close()
+}
+
+func TestDevServer_FileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saltyhash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	newStorage := func() logical.Storage {
+		physical, err := file.NewFileBackend(map[string]string{"path": dir}, hclog.NewNullLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return logical.NewLogicalStorage(physical)
+	}
+
+	_, vault := newDevServer(t, newStorage())
+	if _, err := vault.Logical().Write("saltyhash/roles/test", map[string]interface{}{"salt": testSalt, "mode": "append"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test roles survive restarts
+	_, vault = newDevServer(t, newStorage())
+	secret, err := vault.Logical().Write("saltyhash/hash/test/sha2-256", map[string]interface{}{"input": "c2VjcmV0ZGF0YQ=="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["sum"] != testSum {
+		t.Fatalf("bad sum after restart: %#v", secret.Data)
+	}
+}
+
+func TestDevServer_Requests(t *testing.T) {
+	handler, _ := newDevServer(t, &logical.InmemStorage{})
+
+	// Test URL paths are cleaned before being matched with the mount
+	for urlPath, expected := range map[string]string{
+		"/v1/saltyhash":                 "",
+		"/v1/saltyhash/":                "",
+		"/v1/saltyhash/roles/":          "roles/",
+		"/v1/saltyhash//config":         "config",
+		"/v1/saltyhash/roles/../config": "config",
+	} {
+		if reqPath, ok := handler.mountPath(urlPath); !ok || reqPath != expected {
+			t.Fatalf("%s: expected path %q, got %q, %t", urlPath, expected, reqPath, ok)
+		}
+	}
+	for _, urlPath := range []string{"/v1/saltyhashes/config", "/v1/saltyhash/../sys/raw", "/v1/sys/mounts"} {
+		if reqPath, ok := handler.mountPath(urlPath); ok {
+			t.Fatalf("%s: expected no path in the mount, got %q", urlPath, reqPath)
+		}
+	}
+
+	// Test the token isn't passed on to the backend
+	r := httptest.NewRequest(http.MethodGet, "/v1/saltyhash/config", nil)
+	r.Header.Set("X-Vault-Token", "root")
+	r.Header.Set("Authorization", "Bearer root")
+	r.Header.Set("X-Request-Id", "test")
+	req, err := handler.newRequest(r, "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Headers) != 1 || http.Header(req.Headers).Get("X-Request-Id") != "test" || r.Header.Get("X-Vault-Token") != "root" {
+		t.Fatalf("bad request headers: %v", req.Headers)
+	}
+}
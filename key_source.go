@@ -0,0 +1,216 @@
+package saltyhash
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// keyMaterialCacheTTL is how long key material fetched from a key source
+	// is reused before being fetched again, bounding both the load on the
+	// source and the delay before its key rotations are picked up.
+	keyMaterialCacheTTL = time.Minute
+
+	defaultTransitMountPath = "transit"
+)
+
+// keySource provides the salts of roles referencing key material managed
+// outside the backend instead of a stored salt.
+type keySource interface {
+	// KeyMaterial returns every version of the named key, base64-encoded by
+	// version, along with the latest version.
+	KeyMaterial(ctx context.Context, keyName string) (map[int]string, int, error)
+}
+
+// keySourceFactory builds a key source from the mount config.
+type keySourceFactory func(config *configEntry) (keySource, error)
+
+// cachedKeyMaterial is the key material of a key source, see keyMaterialCacheTTL.
+type cachedKeyMaterial struct {
+	versions      map[int]string
+	latestVersion int
+	fetchedAt     time.Time
+}
+
+// keySourceError is the response to a request failing to resolve the key
+// source of its role. The key source is upstream of the backend, so its
+// failures are reported as a bad gateway rather than an internal error.
+func keySourceError(roleName string, err error) (*logical.Response, error) {
+	msg := fmt.Sprintf("failed to resolve key source of role %s: %s", roleName, err)
+	return logical.ErrorResponse(msg), logical.CodedError(http.StatusBadGateway, msg)
+}
+
+// resolveKeySource fills in the salts of a role referencing a key source, so
+// it hashes the same way as a role with stored salts. The resolved role is for
+// hashing only and must never be written back to storage.
+func (b *backend) resolveKeySource(ctx context.Context, s logical.Storage, role *roleEntry) error {
+	if role.KeySource == "" {
+		return nil
+	}
+
+	material, err := b.getKeyMaterial(ctx, s, role.KeySource, role.KeyName)
+	if err != nil {
+		return err
+	}
+
+	salt, ok := material.versions[material.latestVersion]
+	if !ok {
+		return fmt.Errorf("latest version %d of key %s not returned by key source %s", material.latestVersion, role.KeyName, role.KeySource)
+	}
+
+	role.Salt = salt
+	role.SaltVersion = material.latestVersion
+	role.PreviousSalts = make(map[int]string, len(material.versions))
+	for version, salt := range material.versions {
+		if version != material.latestVersion {
+			role.PreviousSalts[version] = salt
+		}
+	}
+
+	return nil
+}
+
+func (b *backend) getKeyMaterial(ctx context.Context, s logical.Storage, sourceName, keyName string) (*cachedKeyMaterial, error) {
+	cacheKey := sourceName + "/" + keyName
+	now := b.now()
+
+	b.keyMaterialLock.Lock()
+	material, ok := b.keyMaterial[cacheKey]
+	b.keyMaterialLock.Unlock()
+	if ok && now.Sub(material.fetchedAt) < keyMaterialCacheTTL {
+		return material, nil
+	}
+
+	newSource, ok := b.keySources[sourceName]
+	if !ok {
+		return nil, fmt.Errorf("unknown key source %s", sourceName)
+	}
+	config, err := b.getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	source, err := newSource(config)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, latestVersion, err := source.KeyMaterial(ctx, keyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key %s from key source %s: %w", keyName, sourceName, err)
+	}
+
+	material = &cachedKeyMaterial{
+		versions:      versions,
+		latestVersion: latestVersion,
+		fetchedAt:     now,
+	}
+
+	b.keyMaterialLock.Lock()
+	b.keyMaterial[cacheKey] = material
+	b.keyMaterialLock.Unlock()
+
+	return material, nil
+}
+
+// resetKeyMaterial drops the cached key material, so it's fetched again with
+// the current config.
+func (b *backend) resetKeyMaterial() {
+	b.keyMaterialLock.Lock()
+	b.keyMaterial = make(map[string]*cachedKeyMaterial)
+	b.keyMaterialLock.Unlock()
+}
+
+// transitKeySource fetches HMAC keys from a transit mount. Keys must be
+// exportable, and the configured token must be allowed to read
+// <mount>/export/hmac-key/<key>.
+type transitKeySource struct {
+	client    *api.Client
+	mountPath string
+}
+
+func newTransitKeySource(config *configEntry) (keySource, error) {
+	client, mountPath, err := newTransitClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transitKeySource{
+		client:    client,
+		mountPath: mountPath,
+	}, nil
+}
+
+// newTransitClient returns a client of the configured transit mount, along
+// with its mount path.
+func newTransitClient(config *configEntry) (*api.Client, string, error) {
+	if config.TransitAddress == "" {
+		return nil, "", fmt.Errorf("transit key source not configured")
+	}
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = config.TransitAddress
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	client.SetToken(config.TransitToken)
+
+	mountPath := config.TransitMountPath
+	if mountPath == "" {
+		mountPath = defaultTransitMountPath
+	}
+
+	return client, mountPath, nil
+}
+
+func (s *transitKeySource) KeyMaterial(ctx context.Context, keyName string) (map[int]string, int, error) {
+	req := s.client.NewRequest(http.MethodGet, "/v1/"+path.Join(s.mountPath, "export/hmac-key", url.PathEscape(keyName)))
+	resp, err := s.client.RawRequestWithContext(ctx, req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, 0, fmt.Errorf("empty response from transit")
+	}
+
+	keys, ok := secret.Data["keys"].(map[string]interface{})
+	if !ok || len(keys) == 0 {
+		return nil, 0, fmt.Errorf("no keys returned by transit")
+	}
+
+	versions := make(map[int]string, len(keys))
+	latestVersion := 0
+	for rawVersion, rawKey := range keys {
+		version, err := strconv.Atoi(rawVersion)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid key version %q returned by transit", rawVersion)
+		}
+		key, ok := rawKey.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid key of version %d returned by transit", version)
+		}
+
+		versions[version] = key
+		if version > latestVersion {
+			latestVersion = version
+		}
+	}
+
+	return versions, latestVersion, nil
+}
@@ -0,0 +1,236 @@
+package saltyhash
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testKeySource is a key source stand-in serving keys from memory.
+type testKeySource struct {
+	sync.Mutex
+	keys    map[string]map[int]string
+	fetches int
+}
+
+func (s *testKeySource) KeyMaterial(_ context.Context, keyName string) (map[int]string, int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.fetches++
+	versions := make(map[int]string)
+	latestVersion := 0
+	for version, key := range s.keys[keyName] {
+		versions[version] = key
+		if version > latestVersion {
+			latestVersion = version
+		}
+	}
+	if latestVersion == 0 {
+		return nil, 0, errors.New("key not found")
+	}
+
+	return versions, latestVersion, nil
+}
+
+func TestSalty_KeySource(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	source := &testKeySource{
+		keys: map[string]map[int]string{
+			"hmac": {1: testSalt},
+		},
+	}
+	b.keySources["test"] = func(*configEntry) (keySource, error) {
+		return source, nil
+	}
+
+	roleReq := func(roleName string, data map[string]interface{}) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + roleName,
+			Data:      data,
+		}
+	}
+	hashReq := func(roleName string) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "hash/" + roleName + "/sha2-256",
+			Data:      map[string]interface{}{"input": testSecret},
+		}
+	}
+
+	// Test invalid key source settings are refused
+	handleRequest(t, b, storage, roleReq("external", map[string]interface{}{"key_source": "unknown", "key_name": "hmac", "mode": "append"}), true)
+	handleRequest(t, b, storage, roleReq("external", map[string]interface{}{"key_source": "test", "mode": "append"}), true)
+	handleRequest(t, b, storage, roleReq("external", map[string]interface{}{"key_name": "hmac", "mode": "append"}), true)
+	handleRequest(t, b, storage, roleReq("external", map[string]interface{}{"key_source": "test", "key_name": "hmac", "salt": testSalt, "mode": "append"}), true)
+	handleRequest(t, b, storage, roleReq("external", map[string]interface{}{"key_source": "test", "key_name": "hmac", "exportable": true, "mode": "append"}), true)
+
+	// Test key names escaping the path of the key are refused
+	for _, keyName := range []string{"../../sys/raw/logical", "hmac/../other", "a..b", "hmac key", "-hmac"} {
+		handleRequest(t, b, storage, roleReq("external", map[string]interface{}{"key_source": "test", "key_name": keyName, "mode": "append"}), true)
+	}
+
+	handleRequest(t, b, storage, roleReq("stored", map[string]interface{}{"salt": testSalt, "mode": "append"}), false)
+	handleRequest(t, b, storage, roleReq("external", map[string]interface{}{"key_source": "test", "key_name": "hmac", "mode": "append"}), false)
+
+	// Test the key source can't be changed and the role can't be rotated
+	handleRequest(t, b, storage, roleReq("external", map[string]interface{}{"key_name": "other"}), true)
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/external/rotate",
+	}, true)
+
+	// Test sourced salts hash the same way as stored ones
+	expected := handleRequest(t, b, storage, hashReq("stored"), false).Data["sum"]
+	resp := handleRequest(t, b, storage, hashReq("external"), false)
+	if resp.Data["sum"] != expected || resp.Data["salt_version"] != 1 {
+		t.Fatalf("bad sourced hash: %#v, expected sum %s", resp.Data, expected)
+	}
+
+	// Test key material is cached and refreshed after the TTL
+	updatedSalt := base64.StdEncoding.EncodeToString([]byte("updatedSalt"))
+	source.Lock()
+	source.keys["hmac"][2] = updatedSalt
+	source.Unlock()
+
+	resp = handleRequest(t, b, storage, hashReq("external"), false)
+	if resp.Data["sum"] != expected || source.fetches != 1 {
+		t.Fatalf("key material not cached: %#v, %d fetches", resp.Data, source.fetches)
+	}
+
+	now = now.Add(keyMaterialCacheTTL)
+	resp = handleRequest(t, b, storage, hashReq("external"), false)
+	if resp.Data["sum"] == expected || resp.Data["salt_version"] != 2 {
+		t.Fatalf("key material not refreshed: %#v", resp.Data)
+	}
+
+	// Test sums of previous key versions can be rehashed
+	resp = handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rehash/external",
+		Data: map[string]interface{}{
+			"input":        testSecret,
+			"sum":          expected,
+			"salt_version": 1,
+			"algorithm":    "sha2-256",
+		},
+	}, false)
+	if resp.Data["salt_version"] != 2 {
+		t.Fatalf("bad rehash: %#v", resp.Data)
+	}
+
+	// Test key source failures fail the hash requests as a bad gateway
+	handleRequest(t, b, storage, roleReq("missing", map[string]interface{}{"key_source": "test", "key_name": "missing", "mode": "append"}), false)
+	for path, data := range map[string]map[string]interface{}{
+		"hash/missing/sha2-256":        {"input": testSecret},
+		"hash_batch/missing/sha2-256":  {"input": []string{testSecret}},
+		"hash_stream/missing/sha2-256": {"input": testSecret},
+		"rehash/missing":               {"input": testSecret, "sum": expected, "salt_version": 1, "algorithm": "sha2-256"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		coded, ok := err.(logical.HTTPCodedError)
+		if !ok || coded.Code() != http.StatusBadGateway || !resp.IsError() {
+			t.Fatalf("%s: expected bad gateway error response, got: %#v, err: %v", path, resp, err)
+		}
+	}
+
+	// Test roles with a key source are only cloned to another key, so the
+	// clone never computes the same sums
+	cloneReq := func(data map[string]interface{}) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/external/clone",
+			Data:      data,
+		}
+	}
+	handleRequest(t, b, storage, cloneReq(map[string]interface{}{"new_role_name": "cloned"}), true)
+	handleRequest(t, b, storage, cloneReq(map[string]interface{}{"new_role_name": "cloned", "key_name": "hmac"}), true)
+	handleRequest(t, b, storage, cloneReq(map[string]interface{}{"new_role_name": "cloned", "key_name": "../other"}), true)
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/stored/clone",
+		Data:      map[string]interface{}{"new_role_name": "cloned", "key_name": "other"},
+	}, true)
+	source.Lock()
+	source.keys["other"] = map[int]string{1: testSalt}
+	source.Unlock()
+	handleRequest(t, b, storage, cloneReq(map[string]interface{}{"new_role_name": "cloned", "key_name": "other"}), false)
+	cloned, err := b.getRole(context.Background(), storage, "cloned")
+	if err != nil || cloned == nil || cloned.KeySource != "test" || cloned.KeyName != "other" {
+		t.Fatalf("bad cloned role: %#v, err: %v", cloned, err)
+	}
+
+	// Test the stored role entry never holds the sourced salt
+	role, err := b.getRole(context.Background(), storage, "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Salt != "" || len(role.PreviousSalts) != 0 {
+		t.Fatalf("sourced salt written to storage: %#v", role)
+	}
+}
+
+func TestSalty_TransitKeySource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/keys/export/hmac-key/hmac" || r.Header.Get("X-Vault-Token") != "transit-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"name": "hmac",
+				"type": "aes256-gcm96",
+				"keys": map[string]interface{}{
+					"1": testSalt,
+					"2": testUpdatedSalt,
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	if _, err := newTransitKeySource(&configEntry{}); err == nil {
+		t.Fatal("expected error building an unconfigured transit key source")
+	}
+
+	source, err := newTransitKeySource(&configEntry{
+		TransitAddress:   server.URL,
+		TransitMountPath: "keys",
+		TransitToken:     "transit-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions, latestVersion, err := source.KeyMaterial(context.Background(), "hmac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latestVersion != 2 || versions[1] != testSalt || versions[2] != testUpdatedSalt {
+		t.Fatalf("bad key material: %v, latest version %d", versions, latestVersion)
+	}
+
+	if _, _, err := source.KeyMaterial(context.Background(), "other"); err == nil {
+		t.Fatal("expected error fetching a key without permission")
+	}
+}
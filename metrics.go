@@ -0,0 +1,86 @@
+package saltyhash
+
+import (
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+// metricsPrefix is the key prefix of every metric emitted by the backend.
+var metricsPrefix = []string{"secrets", "saltyhash"}
+
+// Error types reported in the error_type label of the errors counter.
+const (
+	errorTypeInvalidRequest       = "invalid_request"
+	errorTypeRoleNotFound         = "role_not_found"
+	errorTypeUnsupportedAlgorithm = "unsupported_algorithm"
+	errorTypeInvalidInput         = "invalid_input"
+	errorTypeRateLimited          = "rate_limited"
+	errorTypeKeySourceFailed      = "key_source_failed"
+	errorTypeSessionNotFound      = "session_not_found"
+)
+
+// unknownLabel is the value of the role and algorithm labels until they're
+// validated, so arbitrary request paths don't create new series.
+const unknownLabel = "unknown"
+
+// hashMetrics emits the telemetry of a single request to a hash endpoint,
+// labeled by role and algorithm.
+type hashMetrics struct {
+	endpoint string
+	labels   []metrics.Label
+	start    time.Time
+}
+
+// newHashMetrics starts measuring the latency of the request. The algorithm
+// label is only set if the algorithm is supported, and the role label once
+// the role is found, see roleFound.
+func newHashMetrics(endpoint, algorithm string) *hashMetrics {
+	if !hasher.IsSupportedAlgorithm(algorithm) {
+		algorithm = unknownLabel
+	}
+
+	return &hashMetrics{
+		endpoint: endpoint,
+		labels: []metrics.Label{
+			{Name: "role", Value: unknownLabel},
+			{Name: "algorithm", Value: algorithm},
+		},
+		start: time.Now(),
+	}
+}
+
+// roleFound labels the metrics of the request with the role, once it's been
+// read from storage.
+func (m *hashMetrics) roleFound(roleName string) {
+	m.labels[0].Value = roleName
+}
+
+func (m *hashMetrics) key(name string) []string {
+	key := make([]string, 0, len(metricsPrefix)+2)
+	key = append(key, metricsPrefix...)
+	return append(key, m.endpoint, name)
+}
+
+// done counts the request and records its latency, whether it failed or not.
+func (m *hashMetrics) done() {
+	metrics.IncrCounterWithLabels(m.key("requests"), 1, m.labels)
+	metrics.MeasureSinceWithLabels(m.key("latency"), m.start, m.labels)
+}
+
+// hashed counts the items hashed by a successful request.
+func (m *hashMetrics) hashed(items int) {
+	metrics.IncrCounterWithLabels(m.key("items"), float32(items), m.labels)
+}
+
+// batchSize records the number of inputs of a batch request.
+func (m *hashMetrics) batchSize(size int) {
+	metrics.AddSampleWithLabels(m.key("batch_size"), float32(size), m.labels)
+}
+
+// failed counts the failed request by the type of the error.
+func (m *hashMetrics) failed(errorType string) {
+	labels := append([]metrics.Label{{Name: "error_type", Value: errorType}}, m.labels...)
+	metrics.IncrCounterWithLabels(m.key("errors"), 1, labels)
+}
@@ -0,0 +1,118 @@
+package saltyhash
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// newTestMetricsSink routes the global metrics to an in-memory sink for the
+// duration of the test.
+func newTestMetricsSink(t *testing.T) *metrics.InmemSink {
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = metrics.NewGlobal(conf, &metrics.BlackholeSink{})
+	})
+
+	return sink
+}
+
+func TestSalty_HashMetrics(t *testing.T) {
+	sink := newTestMetricsSink(t)
+	b, storage := createBackendWithStorage(t)
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt": testSalt,
+			"mode": "append",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []*logical.Request{
+		{
+			Path: hashPath + "/sha1",
+			Data: map[string]interface{}{"input": testSecret},
+		},
+		{
+			Path: hashPath + "/sha1",
+			Data: map[string]interface{}{"input": "not base64"},
+		},
+		{
+			Path: hashPath + "/md5",
+			Data: map[string]interface{}{"input": testSecret},
+		},
+		{
+			Path: hashBatchPath + "/sha1",
+			Data: map[string]interface{}{"input": []string{testSecret, testSecret, testSecret}},
+		},
+		{
+			Path: "hash_batch/missing/sha1",
+			Data: map[string]interface{}{"input": []string{testSecret}},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = storage
+		req.Operation = logical.UpdateOperation
+		_, _ = b.HandleRequest(context.Background(), req)
+	}
+
+	data := sink.Data()
+	if len(data) != 1 {
+		t.Fatalf("expected a single metrics interval, got %d", len(data))
+	}
+	counters := data[0].Counters
+	samples := data[0].Samples
+
+	expectCounter := func(key string, expected float64) {
+		t.Helper()
+		counter, ok := counters[key]
+		if !ok {
+			t.Fatalf("counter %s not emitted, got %v", key, counters)
+		}
+		if counter.Sum != expected {
+			t.Fatalf("counter %s: expected %v, got %v", key, expected, counter.Sum)
+		}
+	}
+
+	expectCounter("secrets.saltyhash.hash.requests;role=test;algorithm=sha1", 2)
+	expectCounter("secrets.saltyhash.hash.items;role=test;algorithm=sha1", 1)
+	expectCounter("secrets.saltyhash.hash.errors;error_type=invalid_input;role=test;algorithm=sha1", 1)
+	expectCounter("secrets.saltyhash.hash.errors;error_type=unsupported_algorithm;role=test;algorithm=unknown", 1)
+	expectCounter("secrets.saltyhash.hash_batch.requests;role=test;algorithm=sha1", 1)
+	expectCounter("secrets.saltyhash.hash_batch.items;role=test;algorithm=sha1", 3)
+	expectCounter("secrets.saltyhash.hash_batch.errors;error_type=role_not_found;role=unknown;algorithm=sha1", 1)
+
+	// Test role and algorithm labels only take validated values
+	for key := range counters {
+		if strings.Contains(key, "role=missing") || strings.Contains(key, "algorithm=md5") {
+			t.Fatalf("unvalidated label emitted: %s", key)
+		}
+	}
+
+	batchSize, ok := samples["secrets.saltyhash.hash_batch.batch_size;role=test;algorithm=sha1"]
+	if !ok || batchSize.Count != 1 || batchSize.Max != 3 {
+		t.Fatalf("bad batch size sample: %v", batchSize)
+	}
+
+	latency, ok := samples["secrets.saltyhash.hash.latency;role=test;algorithm=sha1"]
+	if !ok || latency.Count != 2 {
+		t.Fatalf("bad latency sample: %v", latency)
+	}
+}
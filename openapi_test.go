@@ -0,0 +1,162 @@
+package saltyhash
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+func TestSalty_OpenAPI(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.HelpOperation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, ok := resp.Data["openapi"].(*framework.OASDocument)
+	if !ok {
+		t.Fatalf("missing OpenAPI document: %#v", resp.Data)
+	}
+
+	// Test the document survives a round trip through its JSON form
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var docMap map[string]interface{}
+	if err := json.Unmarshal(raw, &docMap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := framework.NewOASDocumentFromMap(docMap); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Paths) < len(b.Paths) {
+		t.Fatalf("expected at least %d documented paths, got %d", len(b.Paths), len(doc.Paths))
+	}
+
+	// Test every operation is summarized and documents its own responses
+	for path, item := range doc.Paths {
+		if item.Description == "" {
+			t.Errorf("%s: missing description", path)
+		}
+
+		for method, op := range map[string]*framework.OASOperation{"get": item.Get, "post": item.Post, "delete": item.Delete} {
+			if op == nil {
+				continue
+			}
+			if op.Summary == "" {
+				t.Errorf("%s %s: missing summary", method, path)
+			}
+			if len(op.Responses) == 0 {
+				t.Errorf("%s %s: missing responses", method, path)
+			}
+			for code, response := range op.Responses {
+				if response == framework.OASStdRespOK || response == framework.OASStdRespNoContent {
+					t.Errorf("%s %s: default %d response", method, path, code)
+				}
+				if code == 200 && response.Content["application/json"] == nil {
+					t.Errorf("%s %s: missing example of the %d response", method, path, code)
+				}
+			}
+		}
+	}
+
+	// Test create is distinguished from update where it matters
+	for _, path := range []string{"/roles/{role_name}", "/role_templates/{template_name}", "/config"} {
+		if item := doc.Paths[path]; item == nil || !item.CreateSupported {
+			t.Errorf("%s: create not supported", path)
+		}
+	}
+
+	// Test allowed values and required markers
+	bodySchema := func(path string) *framework.OASSchema {
+		item := doc.Paths[path]
+		if item == nil || item.Post == nil || item.Post.RequestBody == nil {
+			t.Fatalf("%s: missing request body", path)
+		}
+		return item.Post.RequestBody.Content["application/json"].Schema
+	}
+
+	if enum := bodySchema("/roles/{role_name}").Properties["mode"].Enum; !reflect.DeepEqual(enum, allowedSaltModes) {
+		t.Errorf("bad mode enum: %v", enum)
+	}
+	if enum := bodySchema("/rehash/{role_name}").Properties["new_algorithm"].Enum; !reflect.DeepEqual(enum, allowedAlgorithms) {
+		t.Errorf("bad new_algorithm enum: %v", enum)
+	}
+	if required := bodySchema("/hash/{role_name}/{algorithm}").Required; !reflect.DeepEqual(required, []string{"input"}) {
+		t.Errorf("bad required hash fields: %v", required)
+	}
+	if !bodySchema("/roles/{role_name}").Properties["salt"].DisplayAttrs.Sensitive {
+		t.Error("salt not marked sensitive")
+	}
+
+	var algorithmParam *framework.OASParameter
+	for i, param := range doc.Paths["/hash/{role_name}/{algorithm}"].Parameters {
+		if param.Name == "algorithm" {
+			algorithmParam = &doc.Paths["/hash/{role_name}/{algorithm}"].Parameters[i]
+		}
+	}
+	if algorithmParam == nil || !algorithmParam.Required || !reflect.DeepEqual(algorithmParam.Schema.Enum, allowedAlgorithms) {
+		t.Errorf("bad algorithm parameter: %#v", algorithmParam)
+	}
+
+	// Test hash_stream only documents the algorithms it streams
+	for _, param := range doc.Paths["/hash_stream/{role_name}/{algorithm}"].Parameters {
+		if param.Name == "algorithm" && !reflect.DeepEqual(param.Schema.Enum, []interface{}{hasher.SHA1, hasher.SHA2256, hasher.SHA2512}) {
+			t.Errorf("bad hash_stream algorithm enum: %v", param.Schema.Enum)
+		}
+	}
+}
+
+func TestSalty_ExistenceCheck(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	exists := func(path string) bool {
+		checkFound, exists, err := b.HandleExistenceCheck(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      path,
+		})
+		if err != nil || !checkFound {
+			t.Fatalf("bad existence check of %s: found %v, err: %v", path, checkFound, err)
+		}
+		return exists
+	}
+
+	paths := []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"roles/" + testRoleName, map[string]interface{}{"salt": testSalt, "mode": "append"}},
+		{"role_templates/billing", map[string]interface{}{"mode": "append"}},
+		{"config", map[string]interface{}{"debug": true}},
+	}
+	for _, p := range paths {
+		if exists(p.path) {
+			t.Fatalf("%s exists before being created", p.path)
+		}
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      p.path,
+			Data:      p.data,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+
+		if !exists(p.path) {
+			t.Fatalf("%s doesn't exist after being created", p.path)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package saltyhash
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathConfigHelpSyn  = `Configure the backend`
+	pathConfigHelpDesc = `This path lets you manage the mount-wide settings of the backend.`
+
+	configStoragePath = "config"
+)
+
+type configEntry struct {
+	// SchemaVersion is the schema version of the stored entry, see
+	// upgradeConfigEntry.
+	SchemaVersion int `json:"schema_version" mapstructure:"-"`
+
+	// Debug enables logging of the shape of hash requests: role, algorithm,
+	// number and size of inputs, never the inputs themselves.
+	Debug bool `json:"debug" mapstructure:"debug"`
+
+	// Connection of the transit key source, see transitKeySource.
+	TransitAddress   string `json:"transit_address" mapstructure:"transit_address"`
+	TransitMountPath string `json:"transit_mount_path" mapstructure:"transit_mount_path"`
+	TransitToken     string `json:"transit_token" mapstructure:"transit_token"`
+
+	// SaltKeyTransitKey is the transit key wrapping the salt key in storage,
+	// see saltKeyEntry. The salt key is stored in plaintext if unset.
+	SaltKeyTransitKey string `json:"salt_key_transit_key" mapstructure:"salt_key_transit_key"`
+
+	// MaxBatchSize caps the number of inputs of hash_batch and rehash batch
+	// requests, unlimited if zero.
+	MaxBatchSize int `json:"max_batch_size" mapstructure:"max_batch_size"`
+
+	// TrustedSigningKeys are the base64-encoded signing public keys of the
+	// mounts whose export bundles import accepts.
+	TrustedSigningKeys []string `json:"trusted_signing_keys" mapstructure:"trusted_signing_keys"`
+
+	// ExportPublicKeys are the PEM-encoded wrapping public keys of the mounts
+	// export may wrap salts for.
+	ExportPublicKeys []string `json:"export_public_keys" mapstructure:"export_public_keys"`
+}
+
+// isExportDestination returns whether the wrapping public key is one of the
+// registered export destinations.
+func (c *configEntry) isExportDestination(publicKey *rsa.PublicKey) bool {
+	fingerprint := wrappingKeyFingerprint(publicKey)
+	for _, key := range c.ExportPublicKeys {
+		registered, err := parseWrappingPublicKey(key)
+		if err == nil && wrappingKeyFingerprint(registered) == fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *configEntry) ToResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"debug":                c.Debug,
+		"transit_address":      c.TransitAddress,
+		"transit_mount_path":   c.TransitMountPath,
+		"salt_key_transit_key": c.SaltKeyTransitKey,
+		"max_batch_size":       c.MaxBatchSize,
+		"trusted_signing_keys": c.TrustedSigningKeys,
+		"export_public_keys":   c.ExportPublicKeys,
+	}
+}
+
+func (b *backend) pathConfig() *framework.Path {
+	return &framework.Path{
+		Pattern: "config$",
+		Fields: map[string]*framework.FieldSchema{
+			"debug": {
+				Type:        framework.TypeBool,
+				Description: "Log the shape of hash requests (role, algorithm, number and size of inputs) for troubleshooting",
+			},
+			"transit_address": {
+				Type:        framework.TypeString,
+				Description: "Address of the Vault server of the transit key source",
+			},
+			"transit_mount_path": {
+				Type:        framework.TypeString,
+				Description: "Mount path of the transit engine of the transit key source, \"transit\" if unset",
+			},
+			"transit_token": {
+				Type:        framework.TypeString,
+				Description: "Token of the transit key source, allowed to export HMAC keys. Never returned on read",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"salt_key_transit_key": {
+				Type:        framework.TypeString,
+				Description: "Name of a key of the transit mount of the transit key source wrapping the key encrypting salts in storage, which is then never stored in plaintext. The token must be allowed to encrypt and decrypt with it. Can't be unset once set",
+			},
+			"max_batch_size": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of inputs of hash_batch and rehash batch requests, unlimited if zero",
+			},
+			"trusted_signing_keys": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Base64-encoded signing public keys of the mounts whose export bundles may be imported, as returned by their wrapping_key endpoint",
+			},
+			"export_public_keys": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "PEM-encoded wrapping public keys of the mounts roles may be exported to, as returned by their wrapping_key endpoint",
+			},
+		},
+
+		ExistenceCheck: b.pathConfigExistenceCheck,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathConfigWrite,
+				Summary:  "Configure the backend.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigWrite,
+				Summary:  "Configure the backend.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigRead,
+				Summary:  "Read the backend configuration.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: (&configEntry{}).ToResponseData(),
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathConfigHelpSyn,
+		HelpDescription: pathConfigHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigExistenceCheck(ctx context.Context, req *logical.Request, _ *framework.FieldData) (bool, error) {
+	entry, err := req.Storage.Get(ctx, configStoragePath)
+	if err != nil {
+		return false, err
+	}
+
+	return entry != nil, nil
+}
+
+func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Work on a copy, the cached config is shared with concurrent requests.
+	entry := *config
+	entry.SchemaVersion = configSchemaVersion
+	if debug, ok := data.GetOk("debug"); ok {
+		entry.Debug = debug.(bool)
+	}
+	if transitAddress, ok := data.GetOk("transit_address"); ok {
+		entry.TransitAddress = transitAddress.(string)
+	}
+	if transitMountPath, ok := data.GetOk("transit_mount_path"); ok {
+		entry.TransitMountPath = transitMountPath.(string)
+	}
+	if transitToken, ok := data.GetOk("transit_token"); ok {
+		entry.TransitToken = transitToken.(string)
+	}
+	if saltKeyTransitKey, ok := data.GetOk("salt_key_transit_key"); ok {
+		if saltKeyTransitKey.(string) == "" && entry.SaltKeyTransitKey != "" {
+			return logical.ErrorResponse("salt_key_transit_key can't be unset once set"), nil
+		}
+		entry.SaltKeyTransitKey = saltKeyTransitKey.(string)
+	}
+	if entry.SaltKeyTransitKey != "" {
+		if err := validateKeyName(entry.SaltKeyTransitKey); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if entry.TransitAddress == "" {
+			return logical.ErrorResponse("salt_key_transit_key requires transit_address"), nil
+		}
+	}
+	if maxBatchSize, ok := data.GetOk("max_batch_size"); ok {
+		entry.MaxBatchSize = maxBatchSize.(int)
+	}
+	if entry.MaxBatchSize < 0 {
+		return logical.ErrorResponse("max_batch_size must be non-negative"), nil
+	}
+	if trustedSigningKeys, ok := data.GetOk("trusted_signing_keys"); ok {
+		entry.TrustedSigningKeys = trustedSigningKeys.([]string)
+		for _, key := range entry.TrustedSigningKeys {
+			if _, err := decodeSigningPublicKey(key); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+	if exportPublicKeys, ok := data.GetOk("export_public_keys"); ok {
+		entry.ExportPublicKeys = exportPublicKeys.([]string)
+		for _, key := range entry.ExportPublicKeys {
+			if _, err := parseWrappingPublicKey(key); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid export public key: %s", err)), nil
+			}
+		}
+	}
+
+	// Wrap the salt key before storing the config, so a transit key that
+	// can't be used is refused rather than breaking every role.
+	if entry.SaltKeyTransitKey != "" {
+		if err := b.wrapSaltKey(ctx, req.Storage, &entry); err != nil {
+			msg := fmt.Sprintf("failed to wrap the salt key: %s", err)
+			return logical.ErrorResponse(msg), logical.CodedError(http.StatusBadGateway, msg)
+		}
+	}
+
+	jsonEntry, err := logical.StorageEntryJSON(configStoragePath, &entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, jsonEntry); err != nil {
+		return nil, err
+	}
+
+	b.configLock.Lock()
+	b.config = &entry
+	b.configLock.Unlock()
+	b.resetKeyMaterial()
+
+	b.Logger().Info("updated config", "debug", entry.Debug, "transit_address", entry.TransitAddress,
+		"salt_key_transit_key", entry.SaltKeyTransitKey,
+		"max_batch_size", entry.MaxBatchSize, "trusted_signing_keys", len(entry.TrustedSigningKeys),
+		"export_public_keys", len(entry.ExportPublicKeys))
+	return nil, nil
+}
+
+func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: config.ToResponseData(),
+	}, nil
+}
+
+// getConfig returns the mount config, cached after the first read. The
+// returned config must not be modified.
+func (b *backend) getConfig(ctx context.Context, s logical.Storage) (*configEntry, error) {
+	b.configLock.RLock()
+	config := b.config
+	b.configLock.RUnlock()
+	if config != nil {
+		return config, nil
+	}
+
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	if b.config != nil {
+		return b.config, nil
+	}
+
+	entry, err := s.Get(ctx, configStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	config = &configEntry{}
+	if entry != nil {
+		if err := entry.DecodeJSON(config); err != nil {
+			return nil, err
+		}
+	}
+	if err := upgradeConfigEntry(config); err != nil {
+		return nil, err
+	}
+
+	b.config = config
+	return config, nil
+}
+
+// invalidateConfig drops the cached config, so it's read from storage again
+// after being changed on another node.
+func (b *backend) invalidateConfig() {
+	b.configLock.Lock()
+	b.config = nil
+	b.configLock.Unlock()
+}
@@ -0,0 +1,100 @@
+package saltyhash
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSalty_ConfigDebugLogging(t *testing.T) {
+	var logs bytes.Buffer
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	config.Logger = hclog.New(&hclog.LoggerOptions{
+		Output: &logs,
+		Level:  hclog.Trace,
+	})
+
+	b := Backend(context.Background(), config)
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	storage := config.StorageView
+
+	doRequest := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+		return resp
+	}
+
+	doRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt": testSalt,
+			"mode": "append",
+		},
+	})
+
+	hashReq := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      hashPath + "/sha1",
+		Data: map[string]interface{}{
+			"input": testSecret,
+		},
+	}
+
+	// Test request shape isn't logged by default
+	doRequest(hashReq)
+	if strings.Contains(logs.String(), "hash request") {
+		t.Fatalf("request shape logged without debug: %s", logs.String())
+	}
+
+	// Test enable debug
+	doRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"debug": true,
+		},
+	})
+	resp := doRequest(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+	})
+	if resp.Data["debug"] != true {
+		t.Fatalf("bad config: %#v", resp.Data)
+	}
+
+	doRequest(hashReq)
+	if !strings.Contains(logs.String(), "hash request") || !strings.Contains(logs.String(), "role="+testRoleName) {
+		t.Fatalf("request shape not logged with debug: %s", logs.String())
+	}
+
+	// Test errors are logged
+	hashReq.Path = "hash/missing/sha1"
+	_, _ = b.HandleRequest(context.Background(), hashReq)
+	if !strings.Contains(logs.String(), "unable to find role") {
+		t.Fatalf("missing role not logged: %s", logs.String())
+	}
+
+	// Test lifecycle events are logged
+	if !strings.Contains(logs.String(), "created role") {
+		t.Fatalf("role creation not logged: %s", logs.String())
+	}
+
+	// Test inputs and salts are never logged
+	for _, secret := range []string{testSecret, "testSecret", testSalt, "testSalt"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Fatalf("logs contain secret %q: %s", secret, logs.String())
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package saltyhash
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathExportHelpSyn  = `Export exportable roles to a signed bundle`
+	pathExportHelpDesc = `Exports the given exportable roles, or all of them if none are given, to a
+bundle which can be consumed by the import endpoint of another mount. Salts are
+wrapped with the public key of the destination mount as returned by its
+wrapping_key endpoint, which must be registered in the export_public_keys of the
+config, and the bundle is signed with the signing key of this mount. Every
+exported salt version is recorded in the salt_exports field of its role, along
+with the caller and the fingerprint of the destination key.`
+
+	exportBundleVersion = 1
+)
+
+// exportBundle is the signed payload moving roles between mounts.
+type exportBundle struct {
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Roles     []exportedRole `json:"roles"`
+}
+
+type exportedRole struct {
+	Name     string            `json:"name"`
+	Mode     string            `json:"mode"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Ciphertext is the latest salt wrapped with the destination wrapping
+	// key, previous salt versions are wrapped the same way.
+	Ciphertext          []byte         `json:"ciphertext"`
+	SaltVersion         int            `json:"salt_version,omitempty"`
+	PreviousCiphertexts map[int][]byte `json:"previous_ciphertexts,omitempty"`
+}
+
+func (b *backend) pathExport() *framework.Path {
+	return &framework.Path{
+		Pattern: "export$",
+		Fields: map[string]*framework.FieldSchema{
+			"public_key": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded wrapping public key of the destination mount, one of the export_public_keys of the config",
+				Required:    true,
+			},
+			"role_names": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Names of the roles to export. All exportable roles are exported if empty",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathExportWrite,
+				Summary:  "Export roles to a signed bundle.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"bundle":             "eyJ2ZXJzaW9uIjoxLCJyb2xlcyI6W119",
+								"signature":          "c2lnbmF0dXJl",
+								"signing_public_key": "cHVibGljIGtleQ==",
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathExportHelpSyn,
+		HelpDescription: pathExportHelpDesc,
+	}
+}
+
+func (b *backend) pathExportWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	publicKey := data.Get("public_key").(string)
+	if publicKey == "" {
+		return logical.ErrorResponse("missing public key of the destination mount"), nil
+	}
+	destination, err := parseWrappingPublicKey(publicKey)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Salts are only ever wrapped for mounts the operator registered, so a
+	// caller can't export them to a key of their own.
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if !config.isExportDestination(destination) {
+		return logical.ErrorResponse("public key is not a registered export destination, add it to export_public_keys in the config"), logical.ErrPermissionDenied
+	}
+	fingerprint := wrappingKeyFingerprint(destination)
+
+	roleNames := data.Get("role_names").([]string)
+	explicit := len(roleNames) > 0
+	if !explicit {
+		roleNames, err = req.Storage.List(ctx, "roles/")
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(roleNames)
+
+	// The roles are locked so the export events recorded on them don't drop
+	// concurrent updates.
+	for _, lock := range locksutil.LocksForKeys(b.roleLocks, roleNames) {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	now := time.Now().UTC()
+	exportedRoles := make(map[string]*roleEntry, len(roleNames))
+	bundle := &exportBundle{
+		Version:   exportBundleVersion,
+		CreatedAt: now,
+		Roles:     make([]exportedRole, 0, len(roleNames)),
+	}
+	for _, name := range roleNames {
+		role, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			if explicit {
+				return logical.ErrorResponse(fmt.Sprintf("role %s not found", name)), nil
+			}
+			continue
+		}
+		if !role.Exportable {
+			if explicit {
+				return logical.ErrorResponse(fmt.Sprintf("role %s is not exportable", name)), nil
+			}
+			continue
+		}
+
+		exported := exportedRole{
+			Name:        name,
+			Mode:        role.Mode,
+			Metadata:    role.Metadata,
+			SaltVersion: role.SaltVersion,
+		}
+
+		salt, _ := base64.StdEncoding.DecodeString(role.Salt)
+		exported.Ciphertext, err = wrapForPublicKey(publicKey, salt)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to wrap salt of role %s: %s", name, err)), nil
+		}
+
+		if len(role.PreviousSalts) > 0 {
+			exported.PreviousCiphertexts = make(map[int][]byte, len(role.PreviousSalts))
+		}
+		for version, previousSalt := range role.PreviousSalts {
+			salt, _ := base64.StdEncoding.DecodeString(previousSalt)
+			exported.PreviousCiphertexts[version], err = wrapForPublicKey(publicKey, salt)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("failed to wrap salt of role %s: %s", name, err)), nil
+			}
+		}
+
+		bundle.Roles = append(bundle.Roles, exported)
+		exportedRoles[name] = role
+	}
+
+	bundleRaw, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := b.getMountKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// The events are stored before the bundle is returned, so no export goes
+	// unrecorded.
+	for name, role := range exportedRoles {
+		versions := []int{role.SaltVersion}
+		for version := range role.PreviousSalts {
+			versions = append(versions, version)
+		}
+		sort.Ints(versions)
+		for _, version := range versions {
+			role.recordSaltExport(saltExportEvent{
+				Time:        now,
+				SaltVersion: version,
+				EntityID:    req.EntityID,
+				DisplayName: req.DisplayName,
+				Destination: fingerprint,
+			})
+		}
+		if err := b.putRole(ctx, req.Storage, name, role); err != nil {
+			return nil, err
+		}
+	}
+
+	b.Logger().Info("exported roles to bundle", "roles", len(bundle.Roles), "destination", fingerprint,
+		"entity_id", req.EntityID, "display_name", req.DisplayName)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"bundle":             base64.StdEncoding.EncodeToString(bundleRaw),
+			"signature":          base64.StdEncoding.EncodeToString(ed25519.Sign(keys.signingKey, bundleRaw)),
+			"signing_public_key": base64.StdEncoding.EncodeToString(keys.signingPublicKey()),
+		},
+	}, nil
+}
@@ -0,0 +1,159 @@
+package saltyhash
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathExportSaltHelpSyn  = `Export the salt of a role as a response-wrapped token`
+	pathExportSaltHelpDesc = `Returns the salt of an exportable role for hashing offline. The response is
+always wrapped in a single-use token, so the salt is only revealed to whoever
+unwraps it. Every export is recorded on the role along with the caller and the
+exported salt version, and is visible in the salt_exports field of the role.`
+
+	defaultSaltExportWrapTTL = 5 * time.Minute
+	maxSaltExportWrapTTL     = time.Hour
+
+	// maxSaltExportEvents is the number of latest exports kept on a role.
+	maxSaltExportEvents = 100
+)
+
+// saltExportEvent records who exported which version of the salt of a role.
+// Destination is the fingerprint of the wrapping key of the mount the salt
+// was exported to by export, empty for export_salt.
+type saltExportEvent struct {
+	Time        time.Time `json:"time" mapstructure:"time"`
+	SaltVersion int       `json:"salt_version" mapstructure:"salt_version"`
+	EntityID    string    `json:"entity_id,omitempty" mapstructure:"entity_id"`
+	DisplayName string    `json:"display_name,omitempty" mapstructure:"display_name"`
+	Destination string    `json:"destination,omitempty" mapstructure:"destination"`
+}
+
+// recordSaltExport appends the event to the exports of the role, keeping the
+// latest maxSaltExportEvents.
+func (r *roleEntry) recordSaltExport(event saltExportEvent) {
+	r.SaltExports = append(r.SaltExports, event)
+	if len(r.SaltExports) > maxSaltExportEvents {
+		r.SaltExports = r.SaltExports[len(r.SaltExports)-maxSaltExportEvents:]
+	}
+}
+
+func (b *backend) pathExportSalt() *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("role_name") + "/export_salt$",
+		Fields: map[string]*framework.FieldSchema{
+			"role_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+			"salt_version": {
+				Type:        framework.TypeInt,
+				Description: "Version of the salt to export, the latest one if unset",
+			},
+			"wrap_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultSaltExportWrapTTL.Seconds()),
+				Description: "TTL of the wrapping token, at most an hour",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathExportSaltWrite,
+				Summary:  "Export the salt of a role as a response-wrapped token.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"salt":         "c2VjcmV0c2FsdA==",
+								"salt_version": 1,
+								"mode":         "append",
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathExportSaltHelpSyn,
+		HelpDescription: pathExportSaltHelpDesc,
+	}
+}
+
+func (b *backend) pathExportSaltWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	roleName := data.Get("role_name").(string)
+	saltVersion := data.Get("salt_version").(int)
+
+	wrapTTL := time.Duration(data.Get("wrap_ttl").(int)) * time.Second
+	if wrapTTL <= 0 || wrapTTL > maxSaltExportWrapTTL {
+		return logical.ErrorResponse(fmt.Sprintf("wrap_ttl must be positive and at most %s", maxSaltExportWrapTTL)), nil
+	}
+
+	// The export is recorded under the lock so concurrent exports and
+	// updates don't drop each other's events.
+	lock := b.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role not found"), nil
+	}
+	if !role.Exportable {
+		return logical.ErrorResponse(fmt.Sprintf("role %s is not exportable", roleName)), nil
+	}
+
+	if saltVersion == 0 {
+		saltVersion = role.SaltVersion
+	}
+	salt, err := role.saltForVersion(saltVersion)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// The event is stored before the salt is returned, so no export goes
+	// unrecorded.
+	role.recordSaltExport(saltExportEvent{
+		Time:        time.Now().UTC(),
+		SaltVersion: saltVersion,
+		EntityID:    req.EntityID,
+		DisplayName: req.DisplayName,
+	})
+	if err := b.putRole(ctx, req.Storage, roleName, role); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("exported role salt", "role", roleName, "salt_version", saltVersion,
+		"entity_id", req.EntityID, "display_name", req.DisplayName)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"salt":         salt,
+			"salt_version": saltVersion,
+			"mode":         role.Mode,
+		},
+		// Vault wraps every response carrying a wrap TTL, whether the client
+		// asked for it or not.
+		WrapInfo: &wrapping.ResponseWrapInfo{
+			TTL: wrapTTL,
+		},
+	}, nil
+}
@@ -0,0 +1,98 @@
+package saltyhash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSalty_ExportSalt(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	roleReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt": testSalt,
+			"mode": "append",
+		},
+	}
+	handleRequest(t, b, storage, roleReq, false)
+
+	exportReq := func(data map[string]interface{}) *logical.Request {
+		return &logical.Request{
+			Storage:     storage,
+			Operation:   logical.UpdateOperation,
+			Path:        "roles/" + testRoleName + "/export_salt",
+			Data:        data,
+			EntityID:    "entity-1",
+			DisplayName: "token-batch",
+		}
+	}
+
+	// Test non-exportable roles are refused
+	handleRequest(t, b, storage, exportReq(nil), true)
+
+	roleReq.Data = map[string]interface{}{"exportable": true}
+	handleRequest(t, b, storage, roleReq, false)
+	handleRequest(t, b, storage, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName + "/rotate",
+	}, false)
+
+	// Test the latest salt is exported wrapped with the default TTL
+	resp := handleRequest(t, b, storage, exportReq(nil), false)
+	if resp.WrapInfo == nil || resp.WrapInfo.TTL != defaultSaltExportWrapTTL {
+		t.Fatalf("bad wrap info: %#v", resp.WrapInfo)
+	}
+	if resp.Data["salt_version"] != 2 || resp.Data["salt"] == testSalt || resp.Data["mode"] != "append" {
+		t.Fatalf("bad exported salt: %#v", resp.Data)
+	}
+
+	// Test previous versions can be exported
+	resp = handleRequest(t, b, storage, exportReq(map[string]interface{}{
+		"salt_version": 1,
+		"wrap_ttl":     "60s",
+	}), false)
+	if resp.WrapInfo == nil || resp.WrapInfo.TTL != time.Minute {
+		t.Fatalf("bad wrap info: %#v", resp.WrapInfo)
+	}
+	if resp.Data["salt"] != testSalt {
+		t.Fatalf("bad exported salt: %#v", resp.Data)
+	}
+
+	handleRequest(t, b, storage, exportReq(map[string]interface{}{"salt_version": 3}), true)
+	handleRequest(t, b, storage, exportReq(map[string]interface{}{"wrap_ttl": "2h"}), true)
+
+	// Test both exports are recorded on the role
+	resp = handleRequest(t, b, storage, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "roles/" + testRoleName,
+	}, false)
+	events := resp.Data["salt_exports"].([]saltExportEvent)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 export events, got %#v", events)
+	}
+	for i, version := range []int{2, 1} {
+		event := events[i]
+		if event.SaltVersion != version || event.EntityID != "entity-1" || event.DisplayName != "token-batch" || event.Time.IsZero() {
+			t.Fatalf("bad export event: %#v", event)
+		}
+	}
+
+	// Test events survive role updates
+	roleReq.Data = map[string]interface{}{"mode": "prepend"}
+	handleRequest(t, b, storage, roleReq, false)
+	role, err := b.getRole(context.Background(), storage, testRoleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(role.SaltExports) != 2 {
+		t.Fatalf("export events lost on update: %#v", role.SaltExports)
+	}
+}
@@ -3,11 +3,12 @@ package saltyhash
 import (
 	"context"
 	"encoding/base64"
-	"encoding/hex"
 	"fmt"
+	"net/http"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
 )
 
 const (
@@ -25,6 +26,7 @@ func (b *backend) pathHash() *framework.Path {
 			"input": {
 				Type:        framework.TypeString,
 				Description: "The base64-encoded input data",
+				Required:    true,
 			},
 
 			"role_name": {
@@ -40,12 +42,25 @@ func (b *backend) pathHash() *framework.Path {
 				* sha2-512
 				* sha3-256
 				* sha3-512`,
+				AllowedValues: allowedAlgorithms,
 			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: b.pathHashWrite,
+				Summary:  "Hash an input with the salt of the role.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"sum":          "675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98",
+								"salt_version": 1,
+							},
+						},
+					}},
+				},
 			},
 		},
 
@@ -57,42 +72,85 @@ func (b *backend) pathHash() *framework.Path {
 func (b *backend) pathHashWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	var err error
 
+	roleName := data.Get("role_name").(string)
+	inputB64 := data.Get("input").(string)
+	algorithm := data.Get("algorithm").(string)
+
+	m := newHashMetrics("hash", algorithm)
+	defer m.done()
+
+	b.debugHashRequest(ctx, req.Storage, "hash", roleName, algorithm, []string{inputB64})
+
 	err = validateFieldSet(data)
 	if err != nil {
+		m.failed(errorTypeInvalidRequest)
+		b.Logger().Debug("invalid hash request", "endpoint", "hash", "role", roleName, "error", err)
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	roleName := data.Get("role_name").(string)
-	inputB64 := data.Get("input").(string)
-	algorithm := data.Get("algorithm").(string)
-
 	role, err := b.getRole(ctx, req.Storage, roleName)
 	if err != nil || role == nil {
+		m.failed(errorTypeRoleNotFound)
+		b.Logger().Warn("unable to find role", "endpoint", "hash", "role", roleName, "error", err)
 		return logical.ErrorResponse(fmt.Sprintf("unable to find role %s: %s", roleName, err)), logical.ErrInvalidRequest
 	}
+	m.roleFound(roleName)
 
-	hf, err := hashFunction(algorithm)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	if err := b.resolveKeySource(ctx, req.Storage, role); err != nil {
+		m.failed(errorTypeKeySourceFailed)
+		b.Logger().Error("failed to resolve role key source", "endpoint", "hash", "role", roleName, "key_source", role.KeySource, "error", err)
+		return keySourceError(roleName, err)
 	}
 
 	salt, _ := base64.StdEncoding.DecodeString(role.Salt)
 
+	h, err := hasher.New(algorithm, salt, role.Mode)
+	if err != nil {
+		m.failed(errorTypeUnsupportedAlgorithm)
+		b.Logger().Debug("unsupported hash algorithm", "endpoint", "hash", "role", roleName, "algorithm", algorithm)
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	input, err := base64.StdEncoding.DecodeString(inputB64)
 	if len(input) == 0 || err != nil {
+		m.failed(errorTypeInvalidInput)
+		b.Logger().Debug("invalid hash input", "endpoint", "hash", "role", roleName, "error", err)
 		return logical.ErrorResponse(fmt.Sprintf("input either empty or contains invalid base64: %s", err)), logical.ErrInvalidRequest
 	}
 
-	input = saltSecret(input, salt, role.Mode)
-
-	_, err = hf.Write(input)
-	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("couldn't hash data: %s", err)), logical.ErrInvalidRequest
+	// Rate limits are applied once the request is known to be valid, so
+	// invalid requests don't take from them.
+	if err := b.allowHash(roleName, role, 1); err != nil {
+		m.failed(errorTypeRateLimited)
+		b.Logger().Warn("hash request rate limited", "endpoint", "hash", "role", roleName, "error", err)
+		return logical.ErrorResponse(err.Error()), logical.CodedError(http.StatusTooManyRequests, err.Error())
 	}
 
+	sumHex := h.SumHex(input)
+
+	m.hashed(1)
+	b.recordUsage(roleName, 1, 0)
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"sum": hex.EncodeToString(hf.Sum(nil)),
+			"sum":          sumHex,
+			"salt_version": role.SaltVersion,
 		},
 	}, nil
 }
+
+// debugHashRequest logs the shape of a hash request if debug is enabled in
+// the config. Inputs are only measured, never logged.
+func (b *backend) debugHashRequest(ctx context.Context, s logical.Storage, endpoint, roleName, algorithm string, inputs []string) {
+	config, err := b.getConfig(ctx, s)
+	if err != nil || !config.Debug {
+		return
+	}
+
+	size := 0
+	for _, input := range inputs {
+		size += len(input)
+	}
+
+	b.Logger().Info("hash request", "endpoint", endpoint, "role", roleName, "algorithm", algorithm,
+		"inputs", len(inputs), "input_bytes", size)
+}
@@ -3,11 +3,14 @@ package saltyhash
 import (
 	"context"
 	"encoding/base64"
-	"encoding/hex"
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
 )
 
 func (b *backend) pathHashBatch() *framework.Path {
@@ -20,6 +23,7 @@ func (b *backend) pathHashBatch() *framework.Path {
 			"input": {
 				Type:        framework.TypeStringSlice,
 				Description: "Array of the base64-encoded inputs",
+				Required:    true,
 			},
 
 			"role_name": {
@@ -35,12 +39,25 @@ func (b *backend) pathHashBatch() *framework.Path {
 				* sha2-512
 				* sha3-256
 				* sha3-512`,
+				AllowedValues: allowedAlgorithms,
 			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: b.pathHashBatchWrite,
+				Summary:  "Hash a batch of inputs with the salt of the role.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"sums":         []string{"675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98"},
+								"salt_version": 1,
+							},
+						},
+					}},
+				},
 			},
 		},
 
@@ -52,51 +69,132 @@ func (b *backend) pathHashBatch() *framework.Path {
 func (b *backend) pathHashBatchWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	var err error
 
+	roleName := data.Get("role_name").(string)
+	inputB64 := data.Get("input").([]string)
+	algorithm := data.Get("algorithm").(string)
+
+	m := newHashMetrics("hash_batch", algorithm)
+	defer m.done()
+
+	b.debugHashRequest(ctx, req.Storage, "hash_batch", roleName, algorithm, inputB64)
+
 	err = validateFieldSet(data)
 	if err != nil {
+		m.failed(errorTypeInvalidRequest)
+		b.Logger().Debug("invalid hash request", "endpoint", "hash_batch", "role", roleName, "error", err)
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	roleName := data.Get("role_name").(string)
-	inputB64 := data.Get("input").([]string)
-	algorithm := data.Get("algorithm").(string)
-
 	role, err := b.getRole(ctx, req.Storage, roleName)
 	if err != nil || role == nil {
+		m.failed(errorTypeRoleNotFound)
+		b.Logger().Warn("unable to find role", "endpoint", "hash_batch", "role", roleName, "error", err)
 		return logical.ErrorResponse(fmt.Sprintf("unable to find role %s: %s", roleName, err)), logical.ErrInvalidRequest
 	}
+	m.roleFound(roleName)
 
-	hf, err := hashFunction(algorithm)
+	m.batchSize(len(inputB64))
+
+	if err := b.checkBatchSize(ctx, req.Storage, len(inputB64)); err != nil {
+		m.failed(errorTypeInvalidRequest)
+		b.Logger().Debug("invalid hash request", "endpoint", "hash_batch", "role", roleName, "error", err)
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if err := b.resolveKeySource(ctx, req.Storage, role); err != nil {
+		m.failed(errorTypeKeySourceFailed)
+		b.Logger().Error("failed to resolve role key source", "endpoint", "hash_batch", "role", roleName, "key_source", role.KeySource, "error", err)
+		return keySourceError(roleName, err)
+	}
+
+	salt, _ := base64.StdEncoding.DecodeString(role.Salt)
+
+	h, err := hasher.New(algorithm, salt, role.Mode)
 	if err != nil {
+		m.failed(errorTypeUnsupportedAlgorithm)
+		b.Logger().Debug("unsupported hash algorithm", "endpoint", "hash_batch", "role", roleName, "algorithm", algorithm)
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	salt, _ := base64.StdEncoding.DecodeString(role.Salt)
+	if err := checkBatchInputsTrimmed(data.Raw["input"]); err != nil {
+		m.failed(errorTypeInvalidInput)
+		b.Logger().Debug("invalid hash input", "endpoint", "hash_batch", "role", roleName, "error", err)
+		return logical.ErrorResponse(fmt.Sprintf("input either empty or contains invalid base64: %s", err)), logical.ErrInvalidRequest
+	}
 
-	retVals := make([]string, 0, len(inputB64))
+	// Inputs are all decoded into a single buffer before being hashed, so
+	// invalid batches are refused before the rate limits of the role are
+	// applied. Only the hex-encoded sums are allocated per input.
+	size := 0
 	for _, s := range inputB64 {
-		input, err := base64.StdEncoding.DecodeString(s)
-		if len(input) == 0 || err != nil {
+		size += base64.StdEncoding.DecodedLen(len(s))
+	}
+	buf := make([]byte, size)
+	inputs := make([][]byte, len(inputB64))
+	for i, s := range inputB64 {
+		n, err := base64.StdEncoding.Decode(buf, []byte(s))
+		if n == 0 || err != nil {
+			m.failed(errorTypeInvalidInput)
+			b.Logger().Debug("invalid hash input", "endpoint", "hash_batch", "role", roleName, "error", err)
 			return logical.ErrorResponse(fmt.Sprintf("input either empty or contains invalid base64: %s", err)), logical.ErrInvalidRequest
 		}
+		inputs[i], buf = buf[:n:n], buf[n:]
+	}
 
-		input = saltSecret(input, salt, role.Mode)
-
-		_, err = hf.Write(input)
-		if err != nil {
-			return logical.ErrorResponse(fmt.Sprintf("couldn't hash data: %s", err)), logical.ErrInvalidRequest
-		}
+	if err := b.allowHash(roleName, role, len(inputB64)); err != nil {
+		m.failed(errorTypeRateLimited)
+		b.Logger().Warn("hash request rate limited", "endpoint", "hash_batch", "role", roleName, "error", err)
+		return logical.ErrorResponse(err.Error()), logical.CodedError(http.StatusTooManyRequests, err.Error())
+	}
 
-		retVals = append(retVals, hex.EncodeToString(hf.Sum(nil)))
-		hf.Reset()
+	var sum [hasher.MaxSize]byte
+	retVals := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		retVals = append(retVals, hasher.EncodeSum(h.AppendSum(sum[:0], input)))
 	}
 
+	m.hashed(len(retVals))
+	b.recordUsage(roleName, 0, len(retVals))
+
 	// Generate the response
 	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"sums": retVals,
+			"sums":         retVals,
+			"salt_version": role.SaltVersion,
 		},
 	}
 
 	return resp, nil
 }
+
+// checkBatchSize refuses batches of more inputs than the max batch size of
+// the config.
+func (b *backend) checkBatchSize(ctx context.Context, s logical.Storage, size int) error {
+	config, err := b.getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config.MaxBatchSize > 0 && size > config.MaxBatchSize {
+		return fmt.Errorf("batch of %d inputs exceeds the max batch size of %d", size, config.MaxBatchSize)
+	}
+
+	return nil
+}
+
+// checkBatchInputsTrimmed returns an error if an input of the batch, as sent,
+// has surrounding whitespace. The framework trims the items of string slices,
+// so such inputs would be accepted by hash_batch while hash refuses them.
+func checkBatchInputsTrimmed(raw interface{}) error {
+	var inputs []string
+	if err := mapstructure.WeakDecode(raw, &inputs); err != nil {
+		return err
+	}
+
+	for i, input := range inputs {
+		if input != strings.TrimSpace(input) {
+			return fmt.Errorf("input %d has surrounding whitespace", i)
+		}
+	}
+
+	return nil
+}
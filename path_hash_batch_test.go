@@ -2,7 +2,9 @@ package saltyhash
 
 import (
 	"context"
+	"encoding/base64"
 	"testing"
+	"testing/quick"
 
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -99,6 +101,13 @@ func TestSalty_HashBatch(t *testing.T) {
 	hashReq.Data["input"] = []string{""}
 	doRequest(hashReq, true, nil)
 
+	// Test inputs with surrounding whitespace
+	hashReq.Data["input"] = []string{" " + testSecret}
+	doRequest(hashReq, true, nil)
+
+	hashReq.Data["input"] = []string{testSecret + "\n"}
+	doRequest(hashReq, true, nil)
+
 	// Test prepend mode
 	roleReq.Data["mode"] = "prepend"
 	if _, err = b.HandleRequest(context.Background(), roleReq); err != nil {
@@ -119,3 +128,90 @@ func TestSalty_HashBatch(t *testing.T) {
 	hashReq.Data["imput"] = []string{testSecret}
 	doRequest(hashReq, true, nil)
 }
+
+func TestSalty_HashBatchMaxSize(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	configReq := func(maxBatchSize interface{}) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data:      map[string]interface{}{"max_batch_size": maxBatchSize},
+		}
+	}
+	hashReq := func(size int) *logical.Request {
+		input := make([]string, size)
+		for i := range input {
+			input[i] = testSecret
+		}
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      hashBatchPath + "/sha2-256",
+			Data:      map[string]interface{}{"input": input},
+		}
+	}
+	rehashReq := func(size int) *logical.Request {
+		batch := make([]interface{}, size)
+		for i := range batch {
+			batch[i] = map[string]interface{}{
+				"input": testSecret,
+				"sum":   "2ff3a303dfa3da97966fb2df3ee499508c365fc1f4c3aef213828e614ab7aa71",
+			}
+		}
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "rehash/" + testRoleName,
+			Data:      map[string]interface{}{"batch_input": batch, "salt_version": 1, "algorithm": "sha2-256"},
+		}
+	}
+
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data:      map[string]interface{}{"salt": testSalt, "mode": "append"},
+	}, false)
+
+	// Test batches are unlimited by default
+	handleRequest(t, b, storage, hashReq(100), false)
+
+	// Test the max batch size applies to hash and rehash batches
+	handleRequest(t, b, storage, configReq(-1), true)
+	handleRequest(t, b, storage, configReq(10), false)
+	if resp := handleRequest(t, b, storage, &logical.Request{Operation: logical.ReadOperation, Path: "config"}, false); resp.Data["max_batch_size"] != 10 {
+		t.Fatalf("bad config: %#v", resp.Data)
+	}
+
+	handleRequest(t, b, storage, hashReq(10), false)
+	handleRequest(t, b, storage, hashReq(11), true)
+	handleRequest(t, b, storage, rehashReq(10), false)
+	handleRequest(t, b, storage, rehashReq(11), true)
+}
+
+func TestSalty_HashBatchProperties(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	createModeRoles(t, b, storage)
+
+	// Test inputs with surrounding whitespace are refused by both endpoints,
+	// and by hash_batch even where hash ignores the newlines
+	for _, prepend := range []bool{false, true} {
+		role, h := modeRoleHasher(t, 0, prepend)
+		checkHashRequests(t, b, storage, role, h, []string{" " + testSecret + "\t", testSecret})
+		checkHashRequests(t, b, storage, role, h, []string{testSecret + "\n", testSecret})
+	}
+
+	// Test item N of hash_batch is hash of input N, inputs being of any
+	// length and order so the decoding buffer is reused
+	property := func(a uint8, prepend bool, inputs [][]byte) bool {
+		role, h := modeRoleHasher(t, a, prepend)
+
+		inputsB64 := make([]string, len(inputs))
+		for i, input := range inputs {
+			inputsB64[i] = base64.StdEncoding.EncodeToString(append(input, byte(i)))
+		}
+		checkHashRequests(t, b, storage, role, h, inputsB64)
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
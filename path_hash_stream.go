@@ -0,0 +1,619 @@
+package saltyhash
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/base62"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+const (
+	pathHashStreamHelpSyn  = `Generate a hash sum in hex format for input data sent in chunks`
+	pathHashStreamHelpDesc = `Generates a hash sum of the given algorithm in hex format against input data
+too large for a single request, sent in chunks over a session:
+
+  * hash_stream/<role>/<algorithm> starts a session, optionally with a first chunk.
+  * hash_stream/<role>/<algorithm>/<session_id> adds a chunk, or aborts the session on delete.
+  * hash_stream/<role>/<algorithm>/<session_id>/finish adds an optional last chunk
+    and returns the sum, ending the session.
+
+The sum is the one returned by hash for the concatenation of the chunks, with
+the salt version of the role when the session started. The state of the hash
+is kept encrypted in storage between chunks, and sessions without chunks for
+their TTL expire. Chunks may set the offset they start at, the length hashed
+so far, so retries of chunks already hashed are refused.
+
+Sessions are only continued, finished or aborted by the entity which started
+them. Every request of a session counts against the requests rate limit of the
+role, and the session against its items rate limit once.
+
+Only algorithms whose state can be saved between requests are supported, in
+both salt modes. There is no HMAC mode, sums are plain salted hashes:
+
+  algorithm   append   prepend
+  sha1        yes      yes
+  sha2-256    yes      yes
+  sha2-512    yes      yes
+  sha3-256    no       no
+  sha3-512    no       no
+
+Sessions of other algorithms are refused with an error naming the streamable
+ones.`
+
+	hashStreamStoragePrefix = "hash_stream/"
+
+	defaultHashStreamTTL = 15 * time.Minute
+	maxHashStreamTTL     = 24 * time.Hour
+
+	hashStreamSessionIDLength = 24
+)
+
+// hashStreamEntry is a session of hash_stream in storage.
+type hashStreamEntry struct {
+	RoleName    string `json:"role_name"`
+	Algorithm   string `json:"algorithm"`
+	SaltVersion int    `json:"salt_version"`
+
+	// EntityID is the entity which started the session, the only one
+	// allowed to continue it.
+	EntityID string `json:"entity_id,omitempty"`
+
+	// State is the marshaled hasher.Stream, sealed with the salt cipher
+	// bound to the storage key. In prepend mode the salt is hashed already,
+	// so the state is as sensitive as the salt.
+	State []byte `json:"state"`
+
+	// Length is the number of input bytes hashed so far.
+	Length int64 `json:"length"`
+
+	TTL        time.Duration `json:"ttl"`
+	ExpireTime time.Time     `json:"expire_time"`
+}
+
+// startedBy returns whether the session exists and was started on the path of
+// the role and algorithm by the entity of the request.
+func (e *hashStreamEntry) startedBy(req *logical.Request, roleName, algorithm string) bool {
+	return e != nil && e.RoleName == roleName && e.Algorithm == algorithm && e.EntityID == req.EntityID
+}
+
+func hashStreamFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"role_name": {
+			Type:        framework.TypeString,
+			Description: "Name of the role",
+		},
+
+		"algorithm": {
+			Type: framework.TypeString,
+			Description: `Algorithm to use (POST URL parameter). Valid values are:
+				* sha1
+				* sha2-256
+				* sha2-512`,
+			AllowedValues: allowedStreamAlgorithms,
+		},
+	}
+}
+
+func (b *backend) pathHashStreamStart() *framework.Path {
+	fields := hashStreamFields()
+	fields["input"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "The base64-encoded first chunk of the input data",
+	}
+	fields["ttl"] = &framework.FieldSchema{
+		Type:        framework.TypeDurationSecond,
+		Default:     int(defaultHashStreamTTL.Seconds()),
+		Description: "Time the session is kept without chunks, at most a day",
+	}
+
+	return &framework.Path{
+		Pattern: "hash_stream/" +
+			framework.GenericNameRegex("role_name") +
+			"/" +
+			framework.GenericNameRegex("algorithm"),
+		Fields: fields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathHashStreamStartWrite,
+				Summary:  "Start hashing an input sent in chunks with the salt of the role.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example:     hashStreamResponseExample(),
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathHashStreamHelpSyn,
+		HelpDescription: pathHashStreamHelpDesc,
+	}
+}
+
+func (b *backend) pathHashStreamChunk() *framework.Path {
+	fields := hashStreamFields()
+	fields["session_id"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "ID of the session",
+	}
+	fields["input"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "The base64-encoded chunk of the input data",
+		Required:    true,
+	}
+	fields["offset"] = &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Default:     -1,
+		Description: "Offset of the chunk in the input, checked against the length hashed so far if set",
+	}
+
+	return &framework.Path{
+		Pattern: "hash_stream/" +
+			framework.GenericNameRegex("role_name") +
+			"/" +
+			framework.GenericNameRegex("algorithm") +
+			"/" +
+			framework.GenericNameRegex("session_id"),
+		Fields: fields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathHashStreamChunkWrite,
+				Summary:  "Add a chunk of the input to a session.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example:     hashStreamResponseExample(),
+					}},
+				},
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathHashStreamDelete,
+				Summary:  "Abort a session.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathHashStreamHelpSyn,
+		HelpDescription: pathHashStreamHelpDesc,
+	}
+}
+
+func (b *backend) pathHashStreamFinish() *framework.Path {
+	fields := hashStreamFields()
+	fields["session_id"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "ID of the session",
+	}
+	fields["input"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "The base64-encoded last chunk of the input data",
+	}
+	fields["offset"] = &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Default:     -1,
+		Description: "Offset of the last chunk in the input, checked against the length hashed so far if set",
+	}
+
+	return &framework.Path{
+		Pattern: "hash_stream/" +
+			framework.GenericNameRegex("role_name") +
+			"/" +
+			framework.GenericNameRegex("algorithm") +
+			"/" +
+			framework.GenericNameRegex("session_id") +
+			"/finish",
+		Fields: fields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathHashStreamFinishWrite,
+				Summary:  "Finish a session, returning the sum of its input.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"sum":          "675cb9ca1ed0c2d4c417c263f0fcc5a9aae12b295c311add34d003f1ac5f2e98",
+								"salt_version": 1,
+								"length":       10,
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathHashStreamHelpSyn,
+		HelpDescription: pathHashStreamHelpDesc,
+	}
+}
+
+func hashStreamResponseExample() *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"session_id":   "3Vr6BkWl2mJ0f8xQhT1zYc4a",
+			"salt_version": 1,
+			"length":       6,
+			"expire_time":  "2020-07-01T12:15:00Z",
+		},
+	}
+}
+
+func hashStreamResponse(sessionID string, entry *hashStreamEntry) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"session_id":   sessionID,
+			"salt_version": entry.SaltVersion,
+			"length":       entry.Length,
+			"expire_time":  entry.ExpireTime,
+		},
+	}
+}
+
+func (b *backend) pathHashStreamStartWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	roleName := data.Get("role_name").(string)
+	algorithm := data.Get("algorithm").(string)
+
+	m := newHashMetrics("hash_stream", algorithm)
+	defer m.done()
+
+	inputB64, hasInput := data.GetOk("input")
+	if hasInput {
+		b.debugHashRequest(ctx, req.Storage, "hash_stream", roleName, algorithm, []string{inputB64.(string)})
+	}
+
+	err = validateFieldSet(data)
+	if err != nil {
+		m.failed(errorTypeInvalidRequest)
+		b.Logger().Debug("invalid hash request", "endpoint", "hash_stream", "role", roleName, "error", err)
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	ttl := time.Duration(data.Get("ttl").(int)) * time.Second
+	if ttl <= 0 || ttl > maxHashStreamTTL {
+		m.failed(errorTypeInvalidRequest)
+		return logical.ErrorResponse(fmt.Sprintf("ttl must be positive and at most %s", maxHashStreamTTL)), nil
+	}
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil || role == nil {
+		m.failed(errorTypeRoleNotFound)
+		b.Logger().Warn("unable to find role", "endpoint", "hash_stream", "role", roleName, "error", err)
+		return logical.ErrorResponse(fmt.Sprintf("unable to find role %s: %s", roleName, err)), logical.ErrInvalidRequest
+	}
+	m.roleFound(roleName)
+
+	// A session is rate limited as the single sum it returns.
+	if err := b.allowHash(roleName, role, 1); err != nil {
+		m.failed(errorTypeRateLimited)
+		b.Logger().Warn("hash request rate limited", "endpoint", "hash_stream", "role", roleName, "error", err)
+		return logical.ErrorResponse(err.Error()), logical.CodedError(http.StatusTooManyRequests, err.Error())
+	}
+
+	entry := &hashStreamEntry{
+		RoleName:    roleName,
+		Algorithm:   algorithm,
+		SaltVersion: role.SaltVersion,
+		EntityID:    req.EntityID,
+		TTL:         ttl,
+	}
+
+	stream, resp, err := b.hashStreamOf(ctx, req.Storage, m, role, entry, nil)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	if hasInput {
+		if resp, err := b.writeHashStream(m, stream, entry, inputB64.(string), -1); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	sessionID, err := base62.Random(hashStreamSessionIDLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.putHashStream(ctx, req.Storage, sessionID, entry, stream); err != nil {
+		return nil, err
+	}
+
+	return hashStreamResponse(sessionID, entry), nil
+}
+
+func (b *backend) pathHashStreamChunkWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.pathHashStreamContinue(ctx, req, data, false)
+}
+
+func (b *backend) pathHashStreamFinishWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.pathHashStreamContinue(ctx, req, data, true)
+}
+
+// pathHashStreamContinue adds a chunk to a session, and returns its sum
+// ending it if finish is set.
+func (b *backend) pathHashStreamContinue(ctx context.Context, req *logical.Request, data *framework.FieldData, finish bool) (*logical.Response, error) {
+	var err error
+
+	roleName := data.Get("role_name").(string)
+	algorithm := data.Get("algorithm").(string)
+	sessionID := data.Get("session_id").(string)
+	offset := int64(data.Get("offset").(int))
+
+	m := newHashMetrics("hash_stream", algorithm)
+	defer m.done()
+
+	inputB64, hasInput := data.GetOk("input")
+	if hasInput {
+		b.debugHashRequest(ctx, req.Storage, "hash_stream", roleName, algorithm, []string{inputB64.(string)})
+	}
+
+	err = validateFieldSet(data)
+	if err != nil {
+		m.failed(errorTypeInvalidRequest)
+		b.Logger().Debug("invalid hash request", "endpoint", "hash_stream", "role", roleName, "error", err)
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	lock := b.hashStreamLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, state, err := b.getHashStream(ctx, req.Storage, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.startedBy(req, roleName, algorithm) {
+		m.failed(errorTypeSessionNotFound)
+		b.Logger().Debug("unable to find hash stream session", "endpoint", "hash_stream", "role", roleName)
+		return logical.ErrorResponse(fmt.Sprintf("session %s not found or expired", sessionID)), logical.ErrInvalidRequest
+	}
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil || role == nil {
+		m.failed(errorTypeRoleNotFound)
+		b.Logger().Warn("unable to find role", "endpoint", "hash_stream", "role", roleName, "error", err)
+		return logical.ErrorResponse(fmt.Sprintf("unable to find role %s: %s", roleName, err)), logical.ErrInvalidRequest
+	}
+	m.roleFound(roleName)
+
+	// Every chunk is a request, the items were taken when the session
+	// started.
+	if err := b.allowHash(roleName, role, 0); err != nil {
+		m.failed(errorTypeRateLimited)
+		b.Logger().Warn("hash request rate limited", "endpoint", "hash_stream", "role", roleName, "error", err)
+		return logical.ErrorResponse(err.Error()), logical.CodedError(http.StatusTooManyRequests, err.Error())
+	}
+
+	stream, resp, err := b.hashStreamOf(ctx, req.Storage, m, role, entry, state)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	// Only finish takes no chunk.
+	if hasInput || !finish {
+		if resp, err := b.writeHashStream(m, stream, entry, data.Get("input").(string), offset); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	if !finish {
+		if err := b.putHashStream(ctx, req.Storage, sessionID, entry, stream); err != nil {
+			return nil, err
+		}
+
+		return hashStreamResponse(sessionID, entry), nil
+	}
+
+	if entry.Length == 0 {
+		m.failed(errorTypeInvalidInput)
+		b.Logger().Debug("invalid hash input", "endpoint", "hash_stream", "role", roleName, "error", "empty input")
+		return logical.ErrorResponse("input empty"), logical.ErrInvalidRequest
+	}
+
+	sumHex := stream.SumHex()
+
+	if err := req.Storage.Delete(ctx, hashStreamStoragePrefix+sessionID); err != nil {
+		return nil, err
+	}
+
+	m.hashed(1)
+	b.recordUsage(roleName, 1, 0)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"sum":          sumHex,
+			"salt_version": entry.SaltVersion,
+			"length":       entry.Length,
+		},
+	}, nil
+}
+
+func (b *backend) pathHashStreamDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role_name").(string)
+	algorithm := data.Get("algorithm").(string)
+	sessionID := data.Get("session_id").(string)
+
+	lock := b.hashStreamLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, _, err := b.getHashStream(ctx, req.Storage, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	// Sessions are only aborted on the path they were started on, by the
+	// entity which started them.
+	if !entry.startedBy(req, roleName, algorithm) {
+		return nil, nil
+	}
+
+	return nil, req.Storage.Delete(ctx, hashStreamStoragePrefix+sessionID)
+}
+
+// hashStreamOf returns the stream of the session with the salt it started
+// with, resumed from the state if any. A response or an error is returned if
+// the stream can't be created, to be returned by the request.
+func (b *backend) hashStreamOf(ctx context.Context, s logical.Storage, m *hashMetrics, role *roleEntry, entry *hashStreamEntry, state []byte) (*hasher.Stream, *logical.Response, error) {
+	if err := b.resolveKeySource(ctx, s, role); err != nil {
+		m.failed(errorTypeKeySourceFailed)
+		b.Logger().Error("failed to resolve role key source", "endpoint", "hash_stream", "role", entry.RoleName, "key_source", role.KeySource, "error", err)
+		resp, err := keySourceError(entry.RoleName, err)
+		return nil, resp, err
+	}
+
+	saltB64, err := role.saltForVersion(entry.SaltVersion)
+	if err != nil {
+		m.failed(errorTypeInvalidRequest)
+		return nil, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	salt, _ := base64.StdEncoding.DecodeString(saltB64)
+
+	h, err := hasher.New(entry.Algorithm, salt, role.Mode)
+	if err == nil && !hasher.IsStreamable(entry.Algorithm) {
+		_, err = h.NewStream()
+	}
+	if err != nil {
+		m.failed(errorTypeUnsupportedAlgorithm)
+		b.Logger().Debug("unsupported hash algorithm", "endpoint", "hash_stream", "role", entry.RoleName, "algorithm", entry.Algorithm)
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	if state == nil {
+		stream, err := h.NewStream()
+		return stream, nil, err
+	}
+	stream, err := h.ResumeStream(state)
+	return stream, nil, err
+}
+
+// writeHashStream writes a chunk to the stream of the session, checking it
+// starts at the offset unless negative. A response is returned if the chunk
+// is invalid, to be returned by the request.
+func (b *backend) writeHashStream(m *hashMetrics, stream *hasher.Stream, entry *hashStreamEntry, inputB64 string, offset int64) (*logical.Response, error) {
+	if offset >= 0 && offset != entry.Length {
+		m.failed(errorTypeInvalidInput)
+		return logical.ErrorResponse(fmt.Sprintf("offset %d doesn't match the length hashed so far, %d", offset, entry.Length)), logical.ErrInvalidRequest
+	}
+
+	input, err := base64.StdEncoding.DecodeString(inputB64)
+	if len(input) == 0 || err != nil {
+		m.failed(errorTypeInvalidInput)
+		b.Logger().Debug("invalid hash input", "endpoint", "hash_stream", "role", entry.RoleName, "error", err)
+		return logical.ErrorResponse(fmt.Sprintf("input either empty or contains invalid base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	_, _ = stream.Write(input)
+	entry.Length += int64(len(input))
+	return nil, nil
+}
+
+// getHashStream returns the session and its decrypted state, or nil if it
+// doesn't exist or expired.
+func (b *backend) getHashStream(ctx context.Context, s logical.Storage, sessionID string) (*hashStreamEntry, []byte, error) {
+	raw, err := s.Get(ctx, hashStreamStoragePrefix+sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if raw == nil {
+		return nil, nil, nil
+	}
+
+	entry := new(hashStreamEntry)
+	if err := raw.DecodeJSON(entry); err != nil {
+		return nil, nil, err
+	}
+	if !b.now().Before(entry.ExpireTime) {
+		return nil, nil, nil
+	}
+
+	aead, err := b.getSaltCipher(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := open(aead, entry.State, []byte(hashStreamStoragePrefix+sessionID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt state of session %s: %w", sessionID, err)
+	}
+
+	return entry, state, nil
+}
+
+// putHashStream stores the session with the state of its stream, extending
+// its expiry by its TTL.
+func (b *backend) putHashStream(ctx context.Context, s logical.Storage, sessionID string, entry *hashStreamEntry, stream *hasher.Stream) error {
+	state, err := stream.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	aead, err := b.getSaltCipher(ctx, s)
+	if err != nil {
+		return err
+	}
+	// The state is bound to the storage key, so it can't be swapped between
+	// sessions, nor with salts bound to role names.
+	if entry.State, err = seal(aead, state, []byte(hashStreamStoragePrefix+sessionID)); err != nil {
+		return err
+	}
+	entry.ExpireTime = b.now().Add(entry.TTL)
+
+	jsonEntry, err := logical.StorageEntryJSON(hashStreamStoragePrefix+sessionID, entry)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, jsonEntry)
+}
+
+// cleanHashStreams deletes the expired sessions from storage.
+func (b *backend) cleanHashStreams(ctx context.Context, s logical.Storage) error {
+	sessionIDs, err := s.List(ctx, hashStreamStoragePrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := b.cleanHashStream(ctx, s, sessionID); err != nil {
+			b.Logger().Error("failed to clean hash stream session", "session_id", sessionID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *backend) cleanHashStream(ctx context.Context, s logical.Storage, sessionID string) error {
+	lock := b.hashStreamLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	raw, err := s.Get(ctx, hashStreamStoragePrefix+sessionID)
+	if err != nil || raw == nil {
+		return err
+	}
+
+	var entry hashStreamEntry
+	if err := raw.DecodeJSON(&entry); err != nil {
+		return err
+	}
+	if b.now().Before(entry.ExpireTime) {
+		return nil
+	}
+
+	return s.Delete(ctx, hashStreamStoragePrefix+sessionID)
+}
+
+func (b *backend) hashStreamLock(sessionID string) *locksutil.LockEntry {
+	return locksutil.LockForKey(b.hashStreamLocks, sessionID)
+}
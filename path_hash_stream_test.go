@@ -0,0 +1,292 @@
+package saltyhash
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+func TestSalty_HashStream(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	createModeRoles(t, b, storage)
+
+	clock := &fakeClock{now: time.Now()}
+	b.now = clock.Now
+
+	doRequest := func(op logical.Operation, path string, data map[string]interface{}, errExpected bool) *logical.Response {
+		return handleRequest(t, b, storage, &logical.Request{Operation: op, Path: path, Data: data}, errExpected)
+	}
+	input := func(s string) map[string]interface{} {
+		return map[string]interface{}{"input": base64.StdEncoding.EncodeToString([]byte(s))}
+	}
+
+	// Test the sum of the chunks is the sum hash returns for the whole input
+	for _, mode := range hasher.Modes() {
+		streamPath := "hash_stream/" + mode + "/" + hasher.SHA2256
+		resp := doRequest(logical.UpdateOperation, streamPath, input("test"), false)
+		sessionID := resp.Data["session_id"].(string)
+		if resp.Data["length"].(int64) != 4 || resp.Data["salt_version"].(int) != 1 {
+			t.Fatalf("%s: bad start response: %#v", mode, resp.Data)
+		}
+
+		resp = doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, input("Sec"), false)
+		if resp.Data["length"].(int64) != 7 {
+			t.Fatalf("%s: bad chunk response: %#v", mode, resp.Data)
+		}
+		resp = doRequest(logical.UpdateOperation, streamPath+"/"+sessionID+"/finish", input("ret"), false)
+
+		expected := doRequest(logical.UpdateOperation, "hash/"+mode+"/"+hasher.SHA2256, map[string]interface{}{"input": testSecret}, false)
+		if resp.Data["sum"] != expected.Data["sum"] || resp.Data["length"].(int64) != 10 {
+			t.Fatalf("%s: expected sum %s, got: %#v", mode, expected.Data["sum"], resp.Data)
+		}
+
+		// Test finished sessions are gone
+		doRequest(logical.UpdateOperation, streamPath+"/"+sessionID+"/finish", nil, true)
+	}
+
+	streamPath := "hash_stream/" + hasher.ModeAppend + "/" + hasher.SHA2512
+	sessionID := doRequest(logical.UpdateOperation, streamPath, nil, false).Data["session_id"].(string)
+
+	// Test sessions are only found on the path they were started on
+	doRequest(logical.UpdateOperation, "hash_stream/"+hasher.ModePrepend+"/"+hasher.SHA2512+"/"+sessionID, input("secret"), true)
+	doRequest(logical.UpdateOperation, "hash_stream/"+hasher.ModeAppend+"/"+hasher.SHA2256+"/"+sessionID, input("secret"), true)
+	doRequest(logical.DeleteOperation, "hash_stream/"+hasher.ModePrepend+"/"+hasher.SHA2512+"/"+sessionID, nil, false)
+
+	// Test invalid chunks, empty inputs and chunks at other offsets are
+	// refused, leaving the session as it was
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID+"/finish", nil, true)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, map[string]interface{}{"input": "!"}, true)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, map[string]interface{}{"input": ""}, true)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, nil, true)
+	data := input("testSe")
+	data["offset"] = 0
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, data, false)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, data, true)
+	data = input("cret")
+	data["offset"] = 6
+	resp := doRequest(logical.UpdateOperation, streamPath+"/"+sessionID+"/finish", data, false)
+	expected := doRequest(logical.UpdateOperation, "hash/"+hasher.ModeAppend+"/"+hasher.SHA2512, map[string]interface{}{"input": testSecret}, false)
+	if resp.Data["sum"] != expected.Data["sum"] {
+		t.Fatalf("expected sum %s, got: %#v", expected.Data["sum"], resp.Data)
+	}
+
+	// Test sessions keep the salt version they started with across rotations
+	streamPath = "hash_stream/" + hasher.ModePrepend + "/" + hasher.SHA1
+	sessionID = doRequest(logical.UpdateOperation, streamPath, input("secret"), false).Data["session_id"].(string)
+	doRequest(logical.UpdateOperation, "roles/"+hasher.ModePrepend+"/rotate", nil, false)
+	resp = doRequest(logical.UpdateOperation, streamPath+"/"+sessionID+"/finish", input("data"), false)
+	h, _ := hasher.NewFromBase64(hasher.SHA1, testSalt, hasher.ModePrepend)
+	if resp.Data["sum"] != h.SumHex([]byte("secretdata")) || resp.Data["salt_version"].(int) != 1 {
+		t.Fatalf("expected sum of salt version 1, got: %#v", resp.Data)
+	}
+
+	// Test aborted sessions are gone
+	streamPath = "hash_stream/" + hasher.ModeAppend + "/" + hasher.SHA1
+	sessionID = doRequest(logical.UpdateOperation, streamPath, input("secret"), false).Data["session_id"].(string)
+	doRequest(logical.DeleteOperation, streamPath+"/"+sessionID, nil, false)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, input("data"), true)
+
+	// Test sessions are only continued, finished and aborted by the entity
+	// which started them
+	sessionID = handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      streamPath,
+		Data:      input("secret"),
+		EntityID:  "owner",
+	}, false).Data["session_id"].(string)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, input("data"), true)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID+"/finish", nil, true)
+	doRequest(logical.DeleteOperation, streamPath+"/"+sessionID, nil, false)
+	resp = handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      streamPath + "/" + sessionID + "/finish",
+		Data:      input("data"),
+		EntityID:  "owner",
+	}, false)
+	if resp.Data["length"].(int64) != 10 {
+		t.Fatalf("bad finish response: %#v", resp.Data)
+	}
+
+	// Test every request of a session is rate limited
+	doRequest(logical.UpdateOperation, "roles/limited", map[string]interface{}{
+		"salt":                testSalt,
+		"mode":                hasher.ModeAppend,
+		"requests_per_second": 1,
+		"requests_burst":      2,
+	}, false)
+	limitedPath := "hash_stream/limited/" + hasher.SHA1
+	sessionID = doRequest(logical.UpdateOperation, limitedPath, input("secret"), false).Data["session_id"].(string)
+	doRequest(logical.UpdateOperation, limitedPath+"/"+sessionID, input("data"), false)
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      limitedPath + "/" + sessionID + "/finish",
+	})
+	if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected rate limited finish, got: %v", err)
+	}
+	clock.Advance(time.Second)
+	if resp := doRequest(logical.UpdateOperation, limitedPath+"/"+sessionID+"/finish", nil, false); resp.Data["length"].(int64) != 10 {
+		t.Fatalf("bad finish response: %#v", resp.Data)
+	}
+
+	// Test algorithms whose state can't be saved, bad TTLs and unknown roles
+	// are refused
+	for _, algorithm := range hasher.Algorithms() {
+		if !hasher.IsStreamable(algorithm) {
+			resp := doRequest(logical.UpdateOperation, "hash_stream/"+hasher.ModeAppend+"/"+algorithm, input("secret"), true)
+			if expected := "streamable algorithms are sha1, sha2-256, sha2-512"; !strings.Contains(resp.Error().Error(), expected) {
+				t.Fatalf("expected error naming the streamable algorithms, got: %v", resp.Error())
+			}
+		}
+	}
+	doRequest(logical.UpdateOperation, "hash_stream/"+hasher.ModeAppend+"/md5", nil, true)
+	doRequest(logical.UpdateOperation, streamPath, map[string]interface{}{"ttl": "25h"}, true)
+	doRequest(logical.UpdateOperation, "hash_stream/unknown/"+hasher.SHA1, nil, true)
+
+	// Test chunks extend the expiry of sessions, and expired sessions are
+	// refused and cleaned up by the periodic function
+	resp = doRequest(logical.UpdateOperation, streamPath, map[string]interface{}{"ttl": "1m"}, false)
+	sessionID = resp.Data["session_id"].(string)
+	if expireTime := resp.Data["expire_time"].(time.Time); !expireTime.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("bad expire time: %s", expireTime)
+	}
+	clock.Advance(50 * time.Second)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, input("secret"), false)
+	clock.Advance(50 * time.Second)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID, input("data"), false)
+	expiredID := doRequest(logical.UpdateOperation, streamPath, map[string]interface{}{"ttl": "1m"}, false).Data["session_id"].(string)
+
+	clock.Advance(time.Minute)
+	doRequest(logical.UpdateOperation, streamPath+"/"+sessionID+"/finish", nil, true)
+	pendingID := doRequest(logical.UpdateOperation, streamPath, nil, false).Data["session_id"].(string)
+	if err := b.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	sessionIDs, err := storage.List(context.Background(), hashStreamStoragePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessionIDs) != 1 || sessionIDs[0] != pendingID {
+		t.Fatalf("expected only session %s left, expired %s, got: %v", pendingID, expiredID, sessionIDs)
+	}
+}
+
+func TestSalty_HashStreamStorage(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	createModeRoles(t, b, storage)
+
+	start := func() string {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "hash_stream/" + hasher.ModePrepend + "/" + hasher.SHA2256,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+		return resp.Data["session_id"].(string)
+	}
+	first, second := start(), start()
+
+	// Test the state, which holds the salt in prepend mode, is encrypted
+	raw, err := storage.Get(context.Background(), hashStreamStoragePrefix+first)
+	if err != nil || raw == nil {
+		t.Fatalf("bad: entry: %#v, err: %v", raw, err)
+	}
+	salt, _ := base64.StdEncoding.DecodeString(testSalt)
+	if bytes.Contains(raw.Value, salt) || strings.Contains(string(raw.Value), testSalt) {
+		t.Fatal("salt stored in the clear")
+	}
+
+	// Test states can't be swapped between sessions
+	var entry hashStreamEntry
+	if err := raw.DecodeJSON(&entry); err != nil {
+		t.Fatal(err)
+	}
+	swapped, err := logical.StorageEntryJSON(hashStreamStoragePrefix+second, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), swapped); err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "hash_stream/" + hasher.ModePrepend + "/" + hasher.SHA2256 + "/" + second,
+		Data:      map[string]interface{}{"input": testSecret},
+	})
+	if err == nil {
+		t.Fatal("expected error resuming a swapped state")
+	}
+}
+
+func TestSalty_HashStreamProperties(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	createModeRoles(t, b, storage)
+
+	// Test the sum of any chunks is the sum of their concatenation
+	property := func(a uint8, prepend bool, chunks [][]byte) bool {
+		role, h := modeRoleHasher(t, a, prepend)
+		if !hasher.IsStreamable(h.Algorithm()) {
+			return true
+		}
+		streamPath := "hash_stream/" + role + "/" + h.Algorithm()
+
+		var input []byte
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      streamPath,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+		sessionID := resp.Data["session_id"].(string)
+
+		for _, chunk := range chunks {
+			if len(chunk) == 0 {
+				continue
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Storage:   storage,
+				Operation: logical.UpdateOperation,
+				Path:      streamPath + "/" + sessionID,
+				Data: map[string]interface{}{
+					"input":  base64.StdEncoding.EncodeToString(chunk),
+					"offset": len(input),
+				},
+			})
+			if err != nil || resp.IsError() {
+				t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+			}
+			input = append(input, chunk...)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      streamPath + "/" + sessionID + "/finish",
+		})
+		if len(input) == 0 {
+			return err != nil && resp.IsError()
+		}
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+
+		return resp.Data["sum"] == h.SumHex(input) && resp.Data["length"].(int64) == int64(len(input))
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,7 +2,9 @@ package saltyhash
 
 import (
 	"context"
+	"encoding/base64"
 	"testing"
+	"testing/quick"
 
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -111,3 +113,38 @@ func TestSalty_Hash(t *testing.T) {
 	hashReq.Data["imput"] = testSecret
 	doRequest(hashReq, true, "")
 }
+
+func TestSalty_HashInvalidInputProperties(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	createModeRoles(t, b, storage)
+
+	// Test invalid inputs, alone or anywhere in a batch, get error
+	// responses
+	for _, invalid := range []string{"", "=", "====", "YQ", "YQ===", "Y Q==", "YQ==YQ==", "\x00", "\xff\xfe", "c2VjcmV0ZGF0YQ==!"} {
+		for _, prepend := range []bool{false, true} {
+			role, h := modeRoleHasher(t, 0, prepend)
+			checkHashRequests(t, b, storage, role, h, []string{invalid})
+			checkHashRequests(t, b, storage, role, h, []string{testSecret, invalid, testSecret})
+		}
+	}
+
+	property := func(a uint8, prepend bool, valid [][]byte, invalid string, position uint8) bool {
+		role, h := modeRoleHasher(t, a, prepend)
+
+		inputs := make([]string, 0, len(valid)+1)
+		for _, input := range valid {
+			inputs = append(inputs, base64.StdEncoding.EncodeToString(append(input, 0)))
+		}
+		if decoded, err := base64.StdEncoding.DecodeString(invalid); err == nil && len(decoded) > 0 {
+			invalid += "!"
+		}
+		i := int(position) % (len(inputs) + 1)
+		inputs = append(inputs[:i], append([]string{invalid}, inputs[i:]...)...)
+
+		checkHashRequests(t, b, storage, role, h, inputs)
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,325 @@
+package saltyhash
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathRoleImportHelpSyn  = `Import a role with an externally generated salt`
+	pathRoleImportHelpDesc = `Creates a role from a salt wrapped with the public key returned by the
+wrapping_key endpoint using RSA-OAEP with SHA-256, so the salt never appears in
+plaintext outside of the backend.`
+	pathImportHelpSyn  = `Import roles from a bundle produced by export`
+	pathImportHelpDesc = `Verifies the signature of a bundle produced by the export endpoint of another
+mount against the trusted_signing_keys of the config of this mount, and creates
+every role contained in it. None of the roles in the bundle may already exist.`
+)
+
+func (b *backend) pathRoleImport() *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("role_name") + "/import$",
+		Fields: map[string]*framework.FieldSchema{
+			"role_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+			"ciphertext": {
+				Type:        framework.TypeString,
+				Description: "The base64-encoded salt wrapped with the wrapping key of this mount",
+				Required:    true,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"mode": {
+				Type: framework.TypeString,
+				Description: `Order of salt application. Valid values are:
+                * append
+                * prepend`,
+				AllowedValues: allowedSaltModes,
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Arbitrary key=value pairs describing the role, usable to filter the role list",
+			},
+			"exportable": {
+				Type:        framework.TypeBool,
+				Description: "Allow the salt to be exported to another mount. Can't be disabled once enabled",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleImportWrite,
+				Summary:  "Import a role with a wrapped salt.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRoleImportHelpSyn,
+		HelpDescription: pathRoleImportHelpDesc,
+	}
+}
+
+func (b *backend) pathImport() *framework.Path {
+	return &framework.Path{
+		Pattern: "import$",
+		Fields: map[string]*framework.FieldSchema{
+			"bundle": {
+				Type:        framework.TypeString,
+				Description: "The bundle returned by the export endpoint",
+				Required:    true,
+			},
+			"signature": {
+				Type:        framework.TypeString,
+				Description: "The base64-encoded signature of the bundle returned by the export endpoint",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathImportWrite,
+				Summary:  "Import roles from an export bundle.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"imported": []string{"test"},
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathImportHelpSyn,
+		HelpDescription: pathImportHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleImportWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	roleName := data.Get("role_name").(string)
+
+	entry := &roleEntry{
+		Mode:             data.Get("mode").(string),
+		Exportable:       data.Get("exportable").(bool),
+		SaltVersion:      1,
+		LastRotationTime: time.Now().UTC(),
+	}
+	if metadata, ok := data.GetOk("metadata"); ok {
+		entry.Metadata = metadata.(map[string]string)
+	}
+
+	if !isValidSaltMode(entry.Mode) {
+		return logical.ErrorResponse("invalid salt mode"), nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(data.Get("ciphertext").(string))
+	if len(ciphertext) == 0 || err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("ciphertext either empty or contains invalid base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	keys, err := b.getMountKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := keys.unwrap(ciphertext)
+	if err != nil {
+		return logical.ErrorResponse("failed to unwrap salt, check that it was wrapped with the current wrapping key"), logical.ErrInvalidRequest
+	}
+	entry.Salt = base64.StdEncoding.EncodeToString(salt)
+
+	lock := b.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role != nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %s already exists", roleName)), nil
+	}
+
+	if err := b.putRole(ctx, req.Storage, roleName, entry); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("imported role", "role", roleName, "mode", entry.Mode)
+	return nil, nil
+}
+
+func (b *backend) pathImportWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	bundleRaw, err := base64.StdEncoding.DecodeString(data.Get("bundle").(string))
+	if len(bundleRaw) == 0 || err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("bundle either empty or contains invalid base64: %s", err)), logical.ErrInvalidRequest
+	}
+	signature, err := base64.StdEncoding.DecodeString(data.Get("signature").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("signature contains invalid base64: %s", err)), logical.ErrInvalidRequest
+	}
+
+	// Bundles are only trusted if signed by a mount the operator trusts, as
+	// anyone can wrap salts for this mount with its public wrapping key.
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.TrustedSigningKeys) == 0 {
+		return logical.ErrorResponse("no trusted signing keys configured, set trusted_signing_keys in the config"), logical.ErrPermissionDenied
+	}
+	verified := false
+	for _, key := range config.TrustedSigningKeys {
+		signingKey, err := decodeSigningPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if ed25519.Verify(signingKey, bundleRaw, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return logical.ErrorResponse("bundle signature verification failed"), logical.ErrPermissionDenied
+	}
+
+	var bundle exportBundle
+	if err := json.Unmarshal(bundleRaw, &bundle); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to decode bundle: %s", err)), logical.ErrInvalidRequest
+	}
+	if bundle.Version != exportBundleVersion {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported bundle version %d", bundle.Version)), logical.ErrInvalidRequest
+	}
+
+	keys, err := b.getMountKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unwrap every salt before storing anything, so a bad bundle doesn't
+	// leave a partial import behind.
+	entries := make(map[string]*roleEntry, len(bundle.Roles))
+	for _, r := range bundle.Roles {
+		if err := validateRoleName(r.Name); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if _, ok := entries[r.Name]; ok {
+			return logical.ErrorResponse(fmt.Sprintf("duplicate role %s in bundle", r.Name)), logical.ErrInvalidRequest
+		}
+		if !isValidSaltMode(r.Mode) {
+			return logical.ErrorResponse(fmt.Sprintf("invalid salt mode of role %s", r.Name)), logical.ErrInvalidRequest
+		}
+
+		salt, err := keys.unwrap(r.Ciphertext)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to unwrap salt of role %s, check that the bundle was exported for this mount", r.Name)), logical.ErrInvalidRequest
+		}
+
+		entry := &roleEntry{
+			Salt:             base64.StdEncoding.EncodeToString(salt),
+			Mode:             r.Mode,
+			Metadata:         r.Metadata,
+			Exportable:       true,
+			SaltVersion:      r.SaltVersion,
+			LastRotationTime: time.Now().UTC(),
+		}
+		if entry.SaltVersion == 0 {
+			entry.SaltVersion = 1
+		}
+		if len(r.PreviousCiphertexts) > 0 {
+			entry.PreviousSalts = make(map[int]string, len(r.PreviousCiphertexts))
+		}
+		for version, ciphertext := range r.PreviousCiphertexts {
+			salt, err := keys.unwrap(ciphertext)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("failed to unwrap salt of role %s, check that the bundle was exported for this mount", r.Name)), logical.ErrInvalidRequest
+			}
+			entry.PreviousSalts[version] = base64.StdEncoding.EncodeToString(salt)
+		}
+		entries[r.Name] = entry
+	}
+
+	imported := make([]string, 0, len(entries))
+	for name := range entries {
+		imported = append(imported, name)
+	}
+	sort.Strings(imported)
+
+	// The roles are checked for conflicts and written under their locks, so
+	// roles created concurrently are never overwritten.
+	locks := locksutil.LocksForKeys(b.roleLocks, imported)
+	for _, lock := range locks {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	var existing []string
+	for _, name := range imported {
+		role, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			existing = append(existing, name)
+		}
+	}
+	if len(existing) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("roles already exist: %s", strings.Join(existing, ", "))), nil
+	}
+
+	for _, name := range imported {
+		if err := b.putRole(ctx, req.Storage, name, entries[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	b.Logger().Info("imported roles from bundle", "roles", imported)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"imported": imported,
+		},
+	}, nil
+}
+
+// decodeSigningPublicKey decodes a base64-encoded ed25519 public key, as
+// returned by the wrapping_key endpoint.
+func decodeSigningPublicKey(key string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if len(raw) != ed25519.PublicKeySize || err != nil {
+		return nil, fmt.Errorf("signing public key %q is not a base64-encoded ed25519 public key", key)
+	}
+
+	return ed25519.PublicKey(raw), nil
+}
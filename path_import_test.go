@@ -0,0 +1,255 @@
+package saltyhash
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSalty_RoleImport(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.ReadOperation,
+		Path:      "wrapping_key",
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+	}
+
+	salt, _ := base64.StdEncoding.DecodeString(testSalt)
+	ciphertext, err := wrapForPublicKey(resp.Data["public_key"].(string), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName + "/import",
+		Data: map[string]interface{}{
+			"ciphertext": base64.StdEncoding.EncodeToString(ciphertext),
+			"mode":       "append",
+		},
+	}
+
+	// Test import
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+	}
+
+	role, err := b.getRole(context.Background(), storage, testRoleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role == nil || role.Salt != testSalt {
+		t.Fatalf("bad imported role: %#v", role)
+	}
+
+	// Test import over an existing role
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err == nil && !resp.IsError() {
+		t.Fatal("bad: got no error response when importing existing role")
+	}
+
+	// Test import of a salt not wrapped with the wrapping key
+	req.Path = "roles/other/import"
+	req.Data["ciphertext"] = base64.StdEncoding.EncodeToString(salt)
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err == nil && !resp.IsError() {
+		t.Fatal("bad: got no error response when importing unwrapped salt")
+	}
+}
+
+func TestSalty_ExportImport(t *testing.T) {
+	src, srcStorage := createBackendWithStorage(t)
+	dst, dstStorage := createBackendWithStorage(t)
+
+	for name, exportable := range map[string]bool{"exported": true, "private": false} {
+		handleRequest(t, src, srcStorage, &logical.Request{
+			Storage:   srcStorage,
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Data: map[string]interface{}{
+				"salt":       testSalt,
+				"mode":       "prepend",
+				"exportable": exportable,
+			},
+		}, false)
+	}
+
+	// Test exportable can't be disabled
+	handleRequest(t, src, srcStorage, &logical.Request{
+		Storage:   srcStorage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/exported",
+		Data: map[string]interface{}{
+			"exportable": false,
+		},
+	}, true)
+
+	wrappingKey := handleRequest(t, dst, dstStorage, &logical.Request{
+		Storage:   dstStorage,
+		Operation: logical.ReadOperation,
+		Path:      "wrapping_key",
+	}, false)
+
+	exportReq := &logical.Request{
+		Storage:   srcStorage,
+		Operation: logical.UpdateOperation,
+		Path:      "export",
+		Data: map[string]interface{}{
+			"public_key": wrappingKey.Data["public_key"],
+			"role_names": "private",
+		},
+	}
+
+	// Test salts are only exported to registered destinations
+	delete(exportReq.Data, "role_names")
+	handleRequest(t, src, srcStorage, exportReq, true)
+	handleRequest(t, src, srcStorage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"export_public_keys": "not a key",
+		},
+	}, true)
+	handleRequest(t, src, srcStorage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"export_public_keys": []interface{}{wrappingKey.Data["public_key"]},
+		},
+	}, false)
+	otherKey := handleRequest(t, src, srcStorage, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "wrapping_key",
+	}, false)
+	exportReq.Data["public_key"] = otherKey.Data["public_key"]
+	handleRequest(t, src, srcStorage, exportReq, true)
+	exportReq.Data["public_key"] = wrappingKey.Data["public_key"]
+
+	// Test export of a non-exportable role
+	exportReq.Data["role_names"] = "private"
+	handleRequest(t, src, srcStorage, exportReq, true)
+
+	// Test export of all exportable roles, recorded on every exported role
+	delete(exportReq.Data, "role_names")
+	export := handleRequest(t, src, srcStorage, exportReq, false)
+	for name, events := range map[string]int{"exported": 1, "private": 0} {
+		role, err := src.getRole(context.Background(), srcStorage, name)
+		if err != nil || role == nil || len(role.SaltExports) != events {
+			t.Fatalf("bad exports of role %s: %#v, err: %v", name, role, err)
+		}
+	}
+	exportedRole, _ := src.getRole(context.Background(), srcStorage, "exported")
+	destination, _ := parseWrappingPublicKey(wrappingKey.Data["public_key"].(string))
+	if event := exportedRole.SaltExports[0]; event.Destination != wrappingKeyFingerprint(destination) || event.SaltVersion != 1 {
+		t.Fatalf("bad export event: %#v", event)
+	}
+
+	importReq := &logical.Request{
+		Storage:   dstStorage,
+		Operation: logical.UpdateOperation,
+		Path:      "import",
+		Data: map[string]interface{}{
+			"bundle":    export.Data["bundle"],
+			"signature": export.Data["signature"],
+		},
+	}
+	trustReq := func(keys ...interface{}) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"trusted_signing_keys": keys,
+			},
+		}
+	}
+
+	// Test bundles are refused unless signed by a trusted key
+	handleRequest(t, dst, dstStorage, importReq, true)
+	handleRequest(t, dst, dstStorage, trustReq("c2lnbmF0dXJl"), true)
+	handleRequest(t, dst, dstStorage, trustReq(wrappingKey.Data["signing_public_key"]), false)
+	handleRequest(t, dst, dstStorage, importReq, true)
+
+	// Test a bundle signed with another key, by someone who wrapped their
+	// own salt with the public wrapping key of the destination
+	publicKey, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedImportReq := func(names ...string) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "import",
+			Data:      signBundle(t, signingKey, wrappingKey.Data["public_key"].(string), names...),
+		}
+	}
+	handleRequest(t, dst, dstStorage, trustReq(wrappingKey.Data["signing_public_key"], export.Data["signing_public_key"]), false)
+	handleRequest(t, dst, dstStorage, signedImportReq("forged"), true)
+
+	// Test bundles with invalid or duplicate role names are refused as a
+	// whole
+	handleRequest(t, dst, dstStorage, trustReq(export.Data["signing_public_key"], base64.StdEncoding.EncodeToString(publicKey)), false)
+	for _, names := range [][]string{{"valid", "a/b"}, {"valid", "../x"}, {"valid", ""}, {"valid", "dup", "dup"}} {
+		handleRequest(t, dst, dstStorage, signedImportReq(names...), true)
+	}
+	if role, err := dst.getRole(context.Background(), dstStorage, "valid"); err != nil || role != nil {
+		t.Fatalf("bad: role imported from a refused bundle: %#v, err: %v", role, err)
+	}
+
+	resp := handleRequest(t, dst, dstStorage, importReq, false)
+	if imported := resp.Data["imported"].([]string); len(imported) != 1 || imported[0] != "exported" {
+		t.Fatalf("bad imported roles: %v", imported)
+	}
+
+	// Test the imported role yields the same sums
+	hashReq := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "hash/exported/sha2-256",
+		Data: map[string]interface{}{
+			"input": testSecret,
+		},
+	}
+	hashReq.Storage = srcStorage
+	expected := handleRequest(t, src, srcStorage, hashReq, false).Data["sum"]
+	hashReq.Storage = dstStorage
+	if sum := handleRequest(t, dst, dstStorage, hashReq, false).Data["sum"]; sum != expected {
+		t.Fatalf("mismatched hashes: %s != %s", sum, expected)
+	}
+
+	// Test repeated import
+	handleRequest(t, dst, dstStorage, importReq, true)
+}
+
+// signBundle returns the import request data of a bundle of roles with the
+// given names and testSalt, wrapped with the public key and signed with the
+// signing key.
+func signBundle(t *testing.T, signingKey ed25519.PrivateKey, publicKey string, names ...string) map[string]interface{} {
+	salt, _ := base64.StdEncoding.DecodeString(testSalt)
+	bundle := &exportBundle{Version: exportBundleVersion}
+	for _, name := range names {
+		ciphertext, err := wrapForPublicKey(publicKey, salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle.Roles = append(bundle.Roles, exportedRole{Name: name, Mode: "append", Ciphertext: ciphertext})
+	}
+	bundleRaw, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]interface{}{
+		"bundle":    base64.StdEncoding.EncodeToString(bundleRaw),
+		"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, bundleRaw)),
+	}
+}
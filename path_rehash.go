@@ -0,0 +1,286 @@
+package saltyhash
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+const (
+	pathRehashHelpSyn  = `Migrate a sum to the latest salt version or another algorithm`
+	pathRehashHelpDesc = `Verifies the given sum against the input under the claimed salt version and
+algorithm in constant time and, if it matches, returns the sum of the input
+under the latest salt version and the new algorithm. Inputs can be given in
+batch with the "batch_input" parameter, in which case every item is verified on
+its own and mismatches are reported as per-item errors. Verified items report
+whether their sum matched with "match", so callers need not parse the errors.
+A mismatch of a single sum fails the request. Valid requests and
+items count against the rate limits of the role like hash requests.`
+)
+
+// rehashItem is a single sum to migrate, also used as batch_input item.
+type rehashItem struct {
+	Input        string `mapstructure:"input"`
+	Sum          string `mapstructure:"sum"`
+	SaltVersion  int    `mapstructure:"salt_version"`
+	Algorithm    string `mapstructure:"algorithm"`
+	NewAlgorithm string `mapstructure:"new_algorithm"`
+}
+
+func (b *backend) pathRehash() *framework.Path {
+	return &framework.Path{
+		Pattern: "rehash/" + framework.GenericNameRegex("role_name"),
+		Fields: map[string]*framework.FieldSchema{
+			"role_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+			"input": {
+				Type:        framework.TypeString,
+				Description: "The base64-encoded input data the sum was computed from",
+			},
+			"sum": {
+				Type:        framework.TypeString,
+				Description: "The hex-encoded sum to migrate",
+			},
+			"salt_version": {
+				Type:        framework.TypeInt,
+				Description: "Salt version the sum was computed with",
+			},
+			"algorithm": {
+				Type:          framework.TypeString,
+				Description:   "Algorithm the sum was computed with",
+				AllowedValues: allowedAlgorithms,
+			},
+			"new_algorithm": {
+				Type:          framework.TypeString,
+				Description:   "Algorithm to compute the new sum with, the same as algorithm if empty",
+				AllowedValues: allowedAlgorithms,
+			},
+			"batch_input": {
+				Type: framework.TypeSlice,
+				Description: `List of items to migrate, each with the input, sum, salt_version,
+algorithm and new_algorithm keys. The top-level parameters are used as
+defaults for salt_version, algorithm and new_algorithm.`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRehashWrite,
+				Summary:  "Migrate a sum to the latest salt version or another algorithm.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"sum":          "3c0ff4c2b0fb0a4e3e4e6b5c3f4b4a2d0f4d1cd4e6e7d5b5c2a0e9f1d3c4b5a6",
+								"salt_version": 2,
+								"algorithm":    "sha2-256",
+								"match":        true,
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRehashHelpSyn,
+		HelpDescription: pathRehashHelpDesc,
+	}
+}
+
+func (b *backend) pathRehashWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	roleName := data.Get("role_name").(string)
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil || role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to find role %s: %s", roleName, err)), logical.ErrInvalidRequest
+	}
+	if err := b.resolveKeySource(ctx, req.Storage, role); err != nil {
+		return keySourceError(roleName, err)
+	}
+
+	defaults := rehashItem{
+		SaltVersion:  data.Get("salt_version").(int),
+		Algorithm:    data.Get("algorithm").(string),
+		NewAlgorithm: data.Get("new_algorithm").(string),
+	}
+
+	batchInputRaw, batch := data.GetOk("batch_input")
+	if !batch {
+		item := defaults
+		item.Input = data.Get("input").(string)
+		item.Sum = data.Get("sum").(string)
+
+		job, err := newRehashJob(role, item)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if err := b.allowHash(roleName, role, 1); err != nil {
+			b.Logger().Warn("hash request rate limited", "endpoint", "rehash", "role", roleName, "error", err)
+			return logical.ErrorResponse(err.Error()), logical.CodedError(http.StatusTooManyRequests, err.Error())
+		}
+
+		result, err := job.run()
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		return &logical.Response{
+			Data: result,
+		}, nil
+	}
+
+	batchInput := batchInputRaw.([]interface{})
+	if len(batchInput) == 0 {
+		return logical.ErrorResponse("missing batch input to process"), logical.ErrInvalidRequest
+	}
+	if err := b.checkBatchSize(ctx, req.Storage, len(batchInput)); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	// Invalid items get their error right away, only the valid ones are
+	// taken from the rate limits of the role and hashed.
+	results := make([]map[string]interface{}, len(batchInput))
+	jobs := make([]*rehashJob, len(batchInput))
+	validItems := 0
+	for i, raw := range batchInput {
+		item := defaults
+		if err := mapstructure.WeakDecode(raw, &item); err != nil {
+			results[i] = map[string]interface{}{
+				"error": fmt.Sprintf("invalid batch item: %s", err),
+			}
+			continue
+		}
+
+		jobs[i], err = newRehashJob(role, item)
+		if err != nil {
+			results[i] = map[string]interface{}{
+				"error": err.Error(),
+			}
+			continue
+		}
+		validItems++
+	}
+
+	if validItems > 0 {
+		if err := b.allowHash(roleName, role, validItems); err != nil {
+			b.Logger().Warn("hash request rate limited", "endpoint", "rehash", "role", roleName, "error", err)
+			return logical.ErrorResponse(err.Error()), logical.CodedError(http.StatusTooManyRequests, err.Error())
+		}
+	}
+
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+
+		result, err := job.run()
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		results[i] = result
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"batch_results": results,
+		},
+	}, nil
+}
+
+// rehashJob is a rehash item checked by newRehashJob, left to be verified
+// and hashed by run.
+type rehashJob struct {
+	item      rehashItem
+	input     []byte
+	sum       []byte
+	oldHasher *hasher.Hasher
+	newHasher *hasher.Hasher
+
+	// saltVersion is the latest salt version of the role, of the new sum.
+	saltVersion int
+}
+
+// newRehashJob checks the item against the role without hashing anything, so
+// invalid items are refused before the rate limits of the role are applied.
+func newRehashJob(role *roleEntry, item rehashItem) (*rehashJob, error) {
+	if item.Algorithm == "" {
+		return nil, fmt.Errorf("missing algorithm the sum was computed with")
+	}
+	if item.SaltVersion == 0 {
+		return nil, fmt.Errorf("missing salt version the sum was computed with")
+	}
+	if item.NewAlgorithm == "" {
+		item.NewAlgorithm = item.Algorithm
+	}
+
+	input, err := base64.StdEncoding.DecodeString(item.Input)
+	if len(input) == 0 || err != nil {
+		return nil, fmt.Errorf("input either empty or contains invalid base64: %v", err)
+	}
+	sum, err := hasher.DecodeSum(item.Sum)
+	if len(sum) == 0 || err != nil {
+		return nil, fmt.Errorf("sum either empty or contains invalid hex: %v", err)
+	}
+
+	oldSaltB64, err := role.saltForVersion(item.SaltVersion)
+	if err != nil {
+		return nil, err
+	}
+	oldSalt, _ := base64.StdEncoding.DecodeString(oldSaltB64)
+
+	oldHasher, err := hasher.New(item.Algorithm, oldSalt, role.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, _ := base64.StdEncoding.DecodeString(role.Salt)
+	newHasher, err := hasher.New(item.NewAlgorithm, salt, role.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rehashJob{
+		item:      item,
+		input:     input,
+		sum:       sum,
+		oldHasher: oldHasher,
+		newHasher: newHasher,
+
+		saltVersion: role.SaltVersion,
+	}, nil
+}
+
+// run verifies the sum of the item under the claimed salt version and
+// algorithm and returns the sum under the latest salt version and the new
+// algorithm. The result reports whether the sum matched, along with the
+// error of a mismatch.
+func (j *rehashJob) run() (map[string]interface{}, error) {
+	if !j.oldHasher.Verify(j.input, j.sum) {
+		return map[string]interface{}{
+			"match": false,
+		}, fmt.Errorf("sum does not match the input under salt version %d and algorithm %s", j.item.SaltVersion, j.item.Algorithm)
+	}
+
+	return map[string]interface{}{
+		"sum":          j.newHasher.SumHex(j.input),
+		"salt_version": j.saltVersion,
+		"algorithm":    j.item.NewAlgorithm,
+		"match":        true,
+	}, nil
+}
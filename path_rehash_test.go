@@ -0,0 +1,175 @@
+package saltyhash
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"testing/quick"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+func TestSalty_Rehash(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	handleRequest(t, b, storage, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt": testSalt,
+			"mode": "append",
+		},
+	}, false)
+
+	hashReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      hashPath + "/sha1",
+		Data: map[string]interface{}{
+			"input": testSecret,
+		},
+	}
+	oldSum := handleRequest(t, b, storage, hashReq, false).Data["sum"].(string)
+
+	handleRequest(t, b, storage, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName + "/rotate",
+	}, false)
+
+	hashReq.Path = hashPath + "/sha3-256"
+	newSum := handleRequest(t, b, storage, hashReq, false).Data["sum"].(string)
+
+	rehashReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "rehash/" + testRoleName,
+		Data: map[string]interface{}{
+			"input":         testSecret,
+			"sum":           oldSum,
+			"salt_version":  1,
+			"algorithm":     "sha1",
+			"new_algorithm": "sha3-256",
+		},
+	}
+
+	// Test rehash to the latest salt version and another algorithm
+	resp := handleRequest(t, b, storage, rehashReq, false)
+	if resp.Data["sum"] != newSum || resp.Data["salt_version"] != 2 || resp.Data["algorithm"] != "sha3-256" {
+		t.Fatalf("bad rehash result: %#v", resp.Data)
+	}
+
+	// Test sum not matching the input
+	rehashReq.Data["sum"] = newSum
+	handleRequest(t, b, storage, rehashReq, true)
+
+	// Test wrong claimed salt version
+	rehashReq.Data["sum"] = oldSum
+	rehashReq.Data["salt_version"] = 2
+	handleRequest(t, b, storage, rehashReq, true)
+
+	// Test unknown salt version
+	rehashReq.Data["salt_version"] = 5
+	handleRequest(t, b, storage, rehashReq, true)
+
+	// Test batch with per-item errors
+	rehashReq.Data = map[string]interface{}{
+		"salt_version":  1,
+		"algorithm":     "sha1",
+		"new_algorithm": "sha3-256",
+		"batch_input": []interface{}{
+			map[string]interface{}{"input": testSecret, "sum": oldSum},
+			map[string]interface{}{"input": testSecret, "sum": newSum},
+			map[string]interface{}{"input": testSecret, "sum": newSum, "salt_version": "2", "algorithm": "sha3-256"},
+			map[string]interface{}{"input": testSecret, "sum": "nothex"},
+		},
+	}
+	resp = handleRequest(t, b, storage, rehashReq, false)
+	results := resp.Data["batch_results"].([]map[string]interface{})
+	if len(results) != 4 {
+		t.Fatalf("expected 4 batch results, got %d", len(results))
+	}
+	if results[0]["sum"] != newSum || results[0]["match"] != true {
+		t.Fatalf("bad batch result: %#v", results[0])
+	}
+	if _, ok := results[1]["error"]; !ok || results[1]["match"] != false {
+		t.Fatalf("expected mismatch error, got %#v", results[1])
+	}
+	if results[2]["sum"] != newSum || results[2]["match"] != true {
+		t.Fatalf("bad batch result: %#v", results[2])
+	}
+
+	// Test items which can't be verified report no match at all
+	if _, ok := results[3]["match"]; ok || results[3]["error"] == nil {
+		t.Fatalf("expected error without match, got %#v", results[3])
+	}
+}
+
+// checkRehashRequest rehashes with a role of createModeRoles, checking the
+// request succeeds with the sum under the new algorithm only if the input
+// and the sum are valid and match, and gets an error response otherwise.
+func checkRehashRequest(t testing.TB, b *backend, storage logical.Storage, role string, inputB64, sumHex string, saltVersion int, algorithm, newAlgorithm string) {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "rehash/" + role,
+		Data: map[string]interface{}{
+			"input":         inputB64,
+			"sum":           sumHex,
+			"salt_version":  saltVersion,
+			"algorithm":     algorithm,
+			"new_algorithm": newAlgorithm,
+		},
+	})
+
+	if newAlgorithm == "" {
+		newAlgorithm = algorithm
+	}
+	input, inputErr := base64.StdEncoding.DecodeString(inputB64)
+	sum, sumErr := hasher.DecodeSum(sumHex)
+	oldHasher, oldErr := hasher.NewFromBase64(algorithm, testSalt, role)
+	newHasher, newErr := hasher.NewFromBase64(newAlgorithm, testSalt, role)
+	valid := len(input) > 0 && inputErr == nil && len(sum) > 0 && sumErr == nil && saltVersion == 1 &&
+		oldErr == nil && newErr == nil && oldHasher.Verify(input, sum)
+
+	if !valid {
+		if err != logical.ErrInvalidRequest || !resp.IsError() {
+			t.Fatalf("%q %q: expected error response, got: %#v, err: %v", inputB64, sumHex, resp, err)
+		}
+		return
+	}
+	if err != nil || resp.IsError() {
+		t.Fatalf("%q %q: bad: resp: %#v, err: %v", inputB64, sumHex, resp, err)
+	}
+	if resp.Data["sum"] != newHasher.SumHex(input) || resp.Data["salt_version"] != 1 || resp.Data["algorithm"] != newAlgorithm {
+		t.Fatalf("%q %q: bad rehash: %#v", inputB64, sumHex, resp.Data)
+	}
+}
+
+func TestSalty_RehashProperties(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	createModeRoles(t, b, storage)
+
+	// Test valid sums are rehashed, and altered sums, inputs and salt
+	// versions get error responses
+	property := func(a, newA uint8, prepend bool, input []byte, alter uint8, saltVersion int) bool {
+		role, h := modeRoleHasher(t, a, prepend)
+		_, newH := modeRoleHasher(t, newA, prepend)
+
+		input = append(input, 0)
+		inputB64 := base64.StdEncoding.EncodeToString(input)
+		sum := h.Sum(input)
+		checkRehashRequest(t, b, storage, role, inputB64, hasher.EncodeSum(sum), 1, h.Algorithm(), newH.Algorithm())
+
+		sum[int(alter)%len(sum)] ^= 1 + alter
+		checkRehashRequest(t, b, storage, role, inputB64, hasher.EncodeSum(sum), 1, h.Algorithm(), "")
+		checkRehashRequest(t, b, storage, role, inputB64+"!", hasher.EncodeSum(sum), 1, h.Algorithm(), "")
+		checkRehashRequest(t, b, storage, role, inputB64, hasher.EncodeSum(h.Sum(input)), saltVersion|2, h.Algorithm(), "")
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
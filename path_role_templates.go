@@ -0,0 +1,293 @@
+package saltyhash
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathListRoleTemplatesHelpSyn  = `List the existing role templates in this backend`
+	pathListRoleTemplatesHelpDesc = `Role templates will be listed by the template name.`
+	pathRoleTemplateHelpSyn       = `Manage the templates roles can be created from.`
+	pathRoleTemplateHelpDesc      = `This path lets you manage the templates which hold the shared settings of
+roles. A role created with the "template" parameter takes every setting it
+doesn't set explicitly from the template and gets a fresh salt unless one is given.`
+)
+
+// roleTemplateEntry holds the role settings shared between roles, everything
+// but the salt.
+type roleTemplateEntry struct {
+	Mode       string            `json:"mode" mapstructure:"mode"`
+	Metadata   map[string]string `json:"metadata,omitempty" mapstructure:"metadata"`
+	Exportable bool              `json:"exportable" mapstructure:"exportable"`
+
+	AutoRotatePeriod time.Duration `json:"auto_rotate_period" mapstructure:"auto_rotate_period"`
+
+	// Rate limits of the roles created from the template, see allowHash.
+	rateLimits `mapstructure:",squash"`
+}
+
+func (t *roleTemplateEntry) ToResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"mode":                t.Mode,
+		"metadata":            t.Metadata,
+		"exportable":          t.Exportable,
+		"auto_rotate_period":  int64(t.AutoRotatePeriod.Seconds()),
+		"requests_per_second": t.RequestsPerSecond,
+		"requests_burst":      t.RequestsBurst,
+		"items_per_second":    t.ItemsPerSecond,
+		"items_burst":         t.ItemsBurst,
+	}
+}
+
+func (b *backend) pathListRoleTemplates() *framework.Path {
+	return &framework.Path{
+		Pattern: "role_templates/?$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathRoleTemplateList,
+				Summary:  "List the role templates.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example:     logical.ListResponse([]string{"billing"}),
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathListRoleTemplatesHelpSyn,
+		HelpDescription: pathListRoleTemplatesHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleTemplates() *framework.Path {
+	return &framework.Path{
+		Pattern: "role_templates/" + framework.GenericNameRegex("template_name"),
+		Fields: map[string]*framework.FieldSchema{
+			"template_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the template",
+			},
+			"mode": {
+				Type: framework.TypeString,
+				Description: `Order of salt application. Valid values are:
+                * append
+                * prepend`,
+				AllowedValues: allowedSaltModes,
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Arbitrary key=value pairs given to the roles created from the template",
+			},
+			"exportable": {
+				Type:        framework.TypeBool,
+				Description: "Allow the salts of the roles created from the template to be exported",
+			},
+			"auto_rotate_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Period after which the salts of the roles created from the template are automatically rotated",
+			},
+			"requests_per_second": {
+				Type:        framework.TypeFloat,
+				Description: "Maximum rate of hash and rehash requests per second of the roles created from the template, unlimited if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Group: "Rate limits",
+				},
+			},
+			"requests_burst": {
+				Type:        framework.TypeInt,
+				Description: "Maximum burst of hash requests of the roles created from the template, the requests rate rounded up if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Group: "Rate limits",
+				},
+			},
+			"items_per_second": {
+				Type:        framework.TypeFloat,
+				Description: "Maximum rate of hashed and rehashed items per second of the roles created from the template, unlimited if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Group: "Rate limits",
+				},
+			},
+			"items_burst": {
+				Type:        framework.TypeInt,
+				Description: "Maximum burst of hashed items of the roles created from the template, which also limits the batch size. The items rate rounded up if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Group: "Rate limits",
+				},
+			},
+		},
+
+		ExistenceCheck: b.pathRoleTemplateExistenceCheck,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathRoleTemplateCreateUpdate,
+				Summary:  "Create a role template.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleTemplateCreateUpdate,
+				Summary:  "Create or update a role template.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRoleTemplateRead,
+				Summary:  "Read a role template.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: (&roleTemplateEntry{
+								Mode:     "append",
+								Metadata: map[string]string{"team": "billing"},
+							}).ToResponseData(),
+						},
+					}},
+				},
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathRoleTemplateDelete,
+				Summary:  "Delete a role template.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRoleTemplateHelpSyn,
+		HelpDescription: pathRoleTemplateHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleTemplateList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, "role_templates/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathRoleTemplateExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	template, err := b.getRoleTemplate(ctx, req.Storage, data.Get("template_name").(string))
+	if err != nil {
+		return false, err
+	}
+
+	return template != nil, nil
+}
+
+func (b *backend) pathRoleTemplateCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	templateName := data.Get("template_name").(string)
+
+	entry, err := b.getRoleTemplate(ctx, req.Storage, templateName)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		entry = &roleTemplateEntry{}
+	}
+
+	if mode, ok := data.GetOk("mode"); ok {
+		entry.Mode = mode.(string)
+	}
+	if metadata, ok := data.GetOk("metadata"); ok {
+		entry.Metadata = metadata.(map[string]string)
+	}
+	if exportable, ok := data.GetOk("exportable"); ok {
+		entry.Exportable = exportable.(bool)
+	}
+	if autoRotatePeriod, ok := data.GetOk("auto_rotate_period"); ok {
+		entry.AutoRotatePeriod = time.Duration(autoRotatePeriod.(int)) * time.Second
+	}
+	if requestsPerSecond, ok := data.GetOk("requests_per_second"); ok {
+		entry.RequestsPerSecond = requestsPerSecond.(float64)
+	}
+	if requestsBurst, ok := data.GetOk("requests_burst"); ok {
+		entry.RequestsBurst = requestsBurst.(int)
+	}
+	if itemsPerSecond, ok := data.GetOk("items_per_second"); ok {
+		entry.ItemsPerSecond = itemsPerSecond.(float64)
+	}
+	if itemsBurst, ok := data.GetOk("items_burst"); ok {
+		entry.ItemsBurst = itemsBurst.(int)
+	}
+
+	if !isValidSaltMode(entry.Mode) {
+		return logical.ErrorResponse("invalid salt mode"), nil
+	}
+	if entry.AutoRotatePeriod != 0 && entry.AutoRotatePeriod < minAutoRotatePeriod {
+		return logical.ErrorResponse(fmt.Sprintf("auto_rotate_period must be zero or at least %s", minAutoRotatePeriod)), nil
+	}
+	if err := entry.rateLimits.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	jsonEntry, err := logical.StorageEntryJSON("role_templates/"+templateName, entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, jsonEntry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathRoleTemplateRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	template, err := b.getRoleTemplate(ctx, req.Storage, data.Get("template_name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		return logical.ErrorResponse("role template not found"), nil
+	}
+
+	return &logical.Response{
+		Data: template.ToResponseData(),
+	}, nil
+}
+
+func (b *backend) pathRoleTemplateDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := req.Storage.Delete(ctx, "role_templates/"+data.Get("template_name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) getRoleTemplate(ctx context.Context, s logical.Storage, n string) (*roleTemplateEntry, error) {
+	entry, err := s.Get(ctx, "role_templates/"+n)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result roleTemplateEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
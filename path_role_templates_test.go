@@ -0,0 +1,102 @@
+package saltyhash
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSalty_RoleTemplate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	templateReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "role_templates/tenant",
+		Data: map[string]interface{}{
+			"mode":                "foobar",
+			"metadata":            "tier=gold",
+			"requests_per_second": 10,
+			"items_burst":         50,
+		},
+	}
+
+	// Test template with invalid mode
+	handleRequest(t, b, storage, templateReq, true)
+
+	// Test template with invalid rate limits
+	templateReq.Data["mode"] = "prepend"
+	templateReq.Data["items_per_second"] = -1
+	handleRequest(t, b, storage, templateReq, true)
+
+	// Test create and read template
+	templateReq.Data["items_per_second"] = 20
+	handleRequest(t, b, storage, templateReq, false)
+
+	templateReq.Operation = logical.ReadOperation
+	resp := handleRequest(t, b, storage, templateReq, false)
+	if resp.Data["mode"] != "prepend" || resp.Data["requests_per_second"] != 10.0 || resp.Data["items_per_second"] != 20.0 || resp.Data["items_burst"] != 50 {
+		t.Fatalf("bad template: %#v", resp.Data)
+	}
+
+	// Test role creation from a template
+	roleReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"template": "tenant",
+		},
+	}
+	handleRequest(t, b, storage, roleReq, false)
+
+	role, err := b.getRole(context.Background(), storage, testRoleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Mode != "prepend" || role.Metadata["tier"] != "gold" || role.Salt == "" ||
+		role.RequestsPerSecond != 10 || role.ItemsPerSecond != 20 || role.ItemsBurst != 50 {
+		t.Fatalf("bad role created from template: %#v", role)
+	}
+
+	// Test template can't be applied to an existing role
+	handleRequest(t, b, storage, roleReq, true)
+
+	// Test explicit settings override the template
+	roleReq.Path = "roles/other"
+	roleReq.Data["mode"] = "append"
+	roleReq.Data["salt"] = testSalt
+	roleReq.Data["items_per_second"] = 0
+	handleRequest(t, b, storage, roleReq, false)
+
+	role, err = b.getRole(context.Background(), storage, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Mode != "append" || role.Salt != testSalt || role.Metadata["tier"] != "gold" ||
+		role.RequestsPerSecond != 10 || role.ItemsPerSecond != 0 {
+		t.Fatalf("bad role created from template: %#v", role)
+	}
+
+	// Test missing template
+	roleReq.Path = "roles/missing"
+	roleReq.Data["template"] = "missing"
+	handleRequest(t, b, storage, roleReq, true)
+
+	// Test list and delete template
+	resp = handleRequest(t, b, storage, &logical.Request{
+		Storage:   storage,
+		Operation: logical.ListOperation,
+		Path:      "role_templates/",
+	}, false)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "tenant" {
+		t.Fatalf("bad template list: %v", keys)
+	}
+
+	templateReq.Operation = logical.DeleteOperation
+	handleRequest(t, b, storage, templateReq, false)
+
+	templateReq.Operation = logical.ReadOperation
+	handleRequest(t, b, storage, templateReq, true)
+}
@@ -2,36 +2,222 @@ package saltyhash
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
+	// minAutoRotatePeriod is the shortest allowed period of automatic salt rotation.
+	minAutoRotatePeriod = time.Hour
+
 	pathListRolesHelpSyn  = `List the existing roles in this backend`
-	pathListRolesHelpDesc = `Roles will be listed by the role name.`
-	pathRoleHelpSyn       = `Manage the roles that can be created with this backend.`
-	pathRoleHelpDesc      = `This path lets you manage the roles that can be created with this backend.`
+	pathListRolesHelpDesc = `Roles will be listed by the role name along with their mode and metadata.
+The list can be filtered by mode and metadata and paginated with the "after" and "limit" parameters.
+Roles not used for hashing within a duration can be listed with the "stale_after" parameter.`
+	pathRoleHelpSyn  = `Manage the roles that can be created with this backend.`
+	pathRoleHelpDesc = `This path lets you manage the roles that can be created with this backend.
+Salts are encrypted in storage with a mount key. Unless salt_key_transit_key is configured, the
+key is kept in plaintext in the storage of the same mount, so a dump of it reveals the salts.`
+	pathRoleCloneHelpSyn  = `Create a role with the settings of an existing one.`
+	pathRoleCloneHelpDesc = `Creates a new role with every setting of the existing role but the salt, which is freshly generated.
+Roles taking their salt from a key source are cloned with the given key_name instead.`
 )
 
 type roleEntry struct {
-	Salt string `json:"salt" mapstructure:"salt"`
-	Mode string `json:"mode" mapstructure:"mode"`
+	// SchemaVersion is the schema version of the stored entry, see
+	// upgradeRoleEntry.
+	SchemaVersion int `json:"schema_version" mapstructure:"-"`
+
+	Salt     string            `json:"salt,omitempty" mapstructure:"salt"`
+	Mode     string            `json:"mode" mapstructure:"mode"`
+	Metadata map[string]string `json:"metadata,omitempty" mapstructure:"metadata"`
+
+	// KeySource is the name of the key source providing the salts of the
+	// role instead of Salt, along with the name of the key in the source.
+	// See resolveKeySource.
+	KeySource string `json:"key_source,omitempty" mapstructure:"key_source"`
+	KeyName   string `json:"key_name,omitempty" mapstructure:"key_name"`
+
+	// Exportable allows the salt to leave the backend through export. Once
+	// enabled it can't be disabled.
+	Exportable bool `json:"exportable" mapstructure:"exportable"`
+
+	// SaltVersion is the version of Salt, the latest one. Salts replaced by
+	// rotation are kept in PreviousSalts by their version.
+	SaltVersion   int            `json:"salt_version" mapstructure:"salt_version"`
+	PreviousSalts map[int]string `json:"previous_salts,omitempty" mapstructure:"previous_salts"`
+
+	// AutoRotatePeriod is the period after which the salt is rotated by the
+	// periodic function, disabled if zero.
+	AutoRotatePeriod time.Duration `json:"auto_rotate_period" mapstructure:"auto_rotate_period"`
+	LastRotationTime time.Time     `json:"last_rotation_time" mapstructure:"last_rotation_time"`
+
+	// SaltExports are the latest exports of the salt through export_salt,
+	// oldest first.
+	SaltExports []saltExportEvent `json:"salt_exports,omitempty" mapstructure:"salt_exports"`
+
+	// Limits of hash requests and hashed items per second, see allowHash.
+	rateLimits `mapstructure:",squash"`
+
+	// Salts encrypted with the mount salt key, which replace Salt and
+	// PreviousSalts in storage, see encryptRoleSalts.
+	EncryptedSalt          string         `json:"encrypted_salt,omitempty" mapstructure:"-"`
+	EncryptedPreviousSalts map[int]string `json:"encrypted_previous_salts,omitempty" mapstructure:"-"`
 }
 
+// ToResponseData returns the role as read. The salt is never returned, it is
+// only handed out response-wrapped and recorded by export_salt.
 func (r *roleEntry) ToResponseData() map[string]interface{} {
 	return map[string]interface{}{
-		"salt": r.Salt,
-		"mode": r.Mode,
+		"mode":                r.Mode,
+		"metadata":            r.Metadata,
+		"key_source":          r.KeySource,
+		"key_name":            r.KeyName,
+		"exportable":          r.Exportable,
+		"salt_version":        r.SaltVersion,
+		"auto_rotate_period":  int64(r.AutoRotatePeriod.Seconds()),
+		"last_rotation_time":  r.LastRotationTime,
+		"requests_per_second": r.RequestsPerSecond,
+		"requests_burst":      r.RequestsBurst,
+		"items_per_second":    r.ItemsPerSecond,
+		"items_burst":         r.ItemsBurst,
+		"salt_exports":        r.SaltExports,
+	}
+}
+
+// saltForVersion returns the salt of the given version, the latest one if
+// version is zero.
+func (r *roleEntry) saltForVersion(version int) (string, error) {
+	if version == 0 || version == r.SaltVersion {
+		return r.Salt, nil
+	}
+
+	salt, ok := r.PreviousSalts[version]
+	if !ok {
+		return "", fmt.Errorf("salt version %d not found", version)
 	}
+
+	return salt, nil
+}
+
+// rotate replaces the salt with a freshly generated one under the next
+// version, keeping the current salt for hashing against older versions.
+func (r *roleEntry) rotate(now time.Time) error {
+	salt, err := generateSalt()
+	if err != nil {
+		return err
+	}
+
+	if r.PreviousSalts == nil {
+		r.PreviousSalts = make(map[int]string)
+	}
+	r.PreviousSalts[r.SaltVersion] = r.Salt
+	r.Salt = salt
+	r.SaltVersion++
+	r.LastRotationTime = now
+
+	return nil
+}
+
+// rotationDue reports whether the salt must be rotated by the periodic function.
+func (r *roleEntry) rotationDue(now time.Time) bool {
+	return r.AutoRotatePeriod > 0 && !now.Before(r.LastRotationTime.Add(r.AutoRotatePeriod))
+}
+
+// ToListInfo returns the non-sensitive subset of the role which is exposed
+// as key_info in the list response.
+func (r *roleEntry) ToListInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"mode":               r.Mode,
+		"metadata":           r.Metadata,
+		"key_source":         r.KeySource,
+		"exportable":         r.Exportable,
+		"salt_version":       r.SaltVersion,
+		"auto_rotate_period": int64(r.AutoRotatePeriod.Seconds()),
+	}
+}
+
+// matchesFilter reports whether the role has the given mode (if set) and
+// contains every given metadata pair.
+func (r *roleEntry) matchesFilter(mode string, metadata map[string]string) bool {
+	if mode != "" && r.Mode != mode {
+		return false
+	}
+	for k, v := range metadata {
+		if rv, ok := r.Metadata[k]; !ok || rv != v {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (b *backend) pathListRoles() *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"mode": {
+				Type:          framework.TypeString,
+				Description:   "Only list roles with the given salt mode",
+				Query:         true,
+				AllowedValues: allowedSaltModes,
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Only list roles containing all of the given metadata key=value pairs",
+				Query:       true,
+			},
+			"after": {
+				Type:        framework.TypeString,
+				Description: "Only list roles which names sort after the given one",
+				Query:       true,
+			},
+			"limit": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of roles to return, unlimited if zero",
+				Query:       true,
+			},
+			"stale_after": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Only list stale roles, which weren't used for hashing within the given duration",
+				Query:       true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathRoleList,
+				Summary:  "List the roles.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: logical.ListResponseWithInfo([]string{"test"}, map[string]interface{}{
+							"test": map[string]interface{}{
+								"mode":               "append",
+								"metadata":           map[string]string{"team": "billing"},
+								"key_source":         "",
+								"exportable":         false,
+								"salt_version":       1,
+								"auto_rotate_period": 0,
+								"last_used":          "2020-07-20T10:00:00Z",
+							},
+						}),
+					}},
+				},
+			},
+		},
 
-		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ListOperation: b.pathRoleList,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Navigation: true,
+			ItemType:   "Role",
 		},
 
 		HelpSynopsis:    pathListRolesHelpSyn,
@@ -39,13 +225,56 @@ func (b *backend) pathListRoles() *framework.Path {
 	}
 }
 
-func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	mode := data.Get("mode").(string)
+	metadata := data.Get("metadata").(map[string]string)
+	after := data.Get("after").(string)
+	limit := data.Get("limit").(int)
+	if limit < 0 {
+		return logical.ErrorResponse("limit must be non-negative"), nil
+	}
+	staleAfter := time.Duration(data.Get("stale_after").(int)) * time.Second
+	now := time.Now().UTC()
+
 	entries, err := req.Storage.List(ctx, "roles/")
 	if err != nil {
 		return nil, err
 	}
+	sort.Strings(entries)
+
+	keys := make([]string, 0, len(entries))
+	keyInfo := make(map[string]interface{}, len(entries))
+	for _, name := range entries {
+		if strings.HasSuffix(name, "/") || (after != "" && name <= after) {
+			continue
+		}
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+
+		role, err := b.getRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil || !role.matchesFilter(mode, metadata) {
+			continue
+		}
+
+		usage, err := b.getUsage(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if staleAfter > 0 && now.Sub(usage.LastUsed) < staleAfter {
+			continue
+		}
+
+		info := role.ToListInfo()
+		info["last_used"] = usage.LastUsed
+		keys = append(keys, name)
+		keyInfo[name] = info
+	}
 
-	return logical.ListResponse(entries), nil
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
 }
 
 func (b *backend) pathRoles() *framework.Path {
@@ -55,37 +284,134 @@ func (b *backend) pathRoles() *framework.Path {
 			"role_name": {
 				Type:        framework.TypeString,
 				Description: "Name of the role",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Role name",
+				},
 			},
 			"salt": {
 				Type:        framework.TypeString,
 				Description: "Random base64-encoded string which will be used as an additional input to hash function",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
 			},
 			"mode": {
 				Type: framework.TypeString,
 				Description: `Order of salt application. Valid values are:
                 * append
                 * prepend`,
+				AllowedValues: allowedSaltModes,
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Arbitrary key=value pairs describing the role, usable to filter the role list",
+			},
+			"key_source": {
+				Type:        framework.TypeString,
+				Description: "Name of the key source providing the salt instead of a stored one. Currently only \"transit\"",
+			},
+			"key_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the key in the key source. Letters, digits, underscores, dashes and single dots, as for transit keys",
+			},
+			"exportable": {
+				Type:        framework.TypeBool,
+				Description: "Allow the salt to be exported to another mount. Can't be disabled once enabled",
+			},
+			"auto_rotate_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Period after which the salt is automatically rotated, at least an hour. Disabled if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Auto-rotate period",
+				},
+			},
+			"requests_per_second": {
+				Type:        framework.TypeFloat,
+				Description: "Maximum rate of hash and rehash requests per second, unlimited if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Group: "Rate limits",
+				},
+			},
+			"requests_burst": {
+				Type:        framework.TypeInt,
+				Description: "Maximum burst of hash requests, the requests rate rounded up if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Group: "Rate limits",
+				},
+			},
+			"items_per_second": {
+				Type:        framework.TypeFloat,
+				Description: "Maximum rate of hashed and rehashed items per second, unlimited if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Group: "Rate limits",
+				},
+			},
+			"items_burst": {
+				Type:        framework.TypeInt,
+				Description: "Maximum burst of hashed items, which also limits the batch size. The items rate rounded up if zero",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Group: "Rate limits",
+				},
+			},
+			"template": {
+				Type:        framework.TypeString,
+				Description: "Name of the role template to take unset settings from on role creation. A fresh salt is generated if none is given",
 			},
 		},
 
+		ExistenceCheck: b.pathRoleExistenceCheck,
+
 		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.pathRoleCreate,
+				Summary:  "Create a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.pathRoleCreateUpdate,
+				Callback: b.pathRoleUpdate,
+				Summary:  "Update a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
 			},
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: b.pathRoleRead,
+				Summary:  "Read a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: (&roleEntry{
+								Mode:        "append",
+								Metadata:    map[string]string{"team": "billing"},
+								SaltVersion: 1,
+							}).ToResponseData(),
+						},
+					}},
+				},
 			},
 			logical.DeleteOperation: &framework.PathOperation{
 				Callback: b.pathRoleDelete,
+				Summary:  "Delete a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
 			},
 		},
 
+		DisplayAttrs: &framework.DisplayAttributes{
+			ItemType: "Role",
+			Action:   "Create",
+		},
+
 		HelpSynopsis:    pathRoleHelpSyn,
 		HelpDescription: pathRoleHelpDesc,
 	}
 }
 
-func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+func (b *backend) pathRoleCreate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	var err error
 
 	err = validateFieldSet(data)
@@ -95,45 +421,314 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 
 	roleName := data.Get("role_name").(string)
 
-	entry := &roleEntry{
-		Salt: data.Get("salt").(string),
-		Mode: data.Get("mode").(string),
+	lock := b.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role != nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %s already exists", roleName)), nil
+	}
+
+	return b.createRole(ctx, req.Storage, roleName, data)
+}
+
+func (b *backend) pathRoleUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
+	roleName := data.Get("role_name").(string)
+
+	// The role is read and written under the lock so concurrent updates and
+	// rotations don't overwrite each other.
+	lock := b.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
 	role, err := b.getRole(ctx, req.Storage, roleName)
 	if err != nil {
 		return nil, err
 	}
+	if role == nil {
+		// Vault routes writes of missing roles to create through the
+		// existence check, this is only reached when called directly.
+		return b.createRole(ctx, req.Storage, roleName, data)
+	}
 
-	if role != nil {
-		if entry.Salt == "" {
-			entry.Salt = role.Salt
+	if data.Get("template").(string) != "" {
+		return logical.ErrorResponse("template can only be used on role creation"), nil
+	}
+	if salt, ok := data.GetOk("salt"); ok && salt.(string) != role.Salt {
+		return logical.ErrorResponse("salt can't be changed once set, rotate the role instead"), nil
+	}
+	if keySource, ok := data.GetOk("key_source"); ok && keySource.(string) != role.KeySource {
+		return logical.ErrorResponse("key_source can't be changed once the role is created"), nil
+	}
+	if keyName, ok := data.GetOk("key_name"); ok && keyName.(string) != role.KeyName {
+		return logical.ErrorResponse("key_name can't be changed once the role is created"), nil
+	}
+	if exportable, ok := data.GetOk("exportable"); ok && role.Exportable && !exportable.(bool) {
+		return logical.ErrorResponse("exportable can't be disabled once enabled"), nil
+	}
+
+	entry := *role
+	applyRoleFields(&entry, data)
+	if err := b.validateRole(&entry); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := b.putRole(ctx, req.Storage, roleName, &entry); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("updated role", "role", roleName, "mode", entry.Mode)
+	return nil, nil
+}
+
+// createRole creates the role from the request, taking the settings it
+// doesn't set from the template if one is given. The role lock must be held.
+func (b *backend) createRole(ctx context.Context, s logical.Storage, roleName string, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	entry := &roleEntry{}
+
+	templateName := data.Get("template").(string)
+	if templateName != "" {
+		template, err := b.getRoleTemplate(ctx, s, templateName)
+		if err != nil {
+			return nil, err
 		}
-		if entry.Mode == "" {
-			entry.Mode = role.Mode
+		if template == nil {
+			return logical.ErrorResponse(fmt.Sprintf("role template %s not found", templateName)), nil
 		}
+
+		entry.Mode = template.Mode
+		entry.Metadata = template.Metadata
+		entry.Exportable = template.Exportable
+		entry.AutoRotatePeriod = template.AutoRotatePeriod
+		entry.rateLimits = template.rateLimits
 	}
+	applyRoleFields(entry, data)
 
-	if entry.Mode != "append" && entry.Mode != "prepend" {
-		return logical.ErrorResponse("invalid salt mode"), nil
+	if entry.Mode == "" {
+		return logical.ErrorResponse("missing salt mode"), nil
 	}
+	if entry.Salt == "" && entry.KeySource == "" {
+		if templateName == "" {
+			return logical.ErrorResponse("missing salt, key source or template to generate the salt from"), nil
+		}
 
-	lock := b.roleLock(roleName)
-	lock.RLock()
-	defer lock.RUnlock()
+		entry.Salt, err = generateSalt()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := b.validateRole(entry); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	entry.SaltVersion = 1
+	entry.LastRotationTime = time.Now().UTC()
+
+	if err := b.putRole(ctx, s, roleName, entry); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("created role", "role", roleName, "mode", entry.Mode, "template", templateName, "key_source", entry.KeySource)
+	return nil, nil
+}
+
+// applyRoleFields sets the role settings present in the request on the entry,
+// leaving the others as they are.
+func applyRoleFields(entry *roleEntry, data *framework.FieldData) {
+	if salt, ok := data.GetOk("salt"); ok {
+		entry.Salt = salt.(string)
+	}
+	if mode, ok := data.GetOk("mode"); ok {
+		entry.Mode = mode.(string)
+	}
+	if metadata, ok := data.GetOk("metadata"); ok {
+		entry.Metadata = metadata.(map[string]string)
+	}
+	if keySource, ok := data.GetOk("key_source"); ok {
+		entry.KeySource = keySource.(string)
+	}
+	if keyName, ok := data.GetOk("key_name"); ok {
+		entry.KeyName = keyName.(string)
+	}
+	if exportable, ok := data.GetOk("exportable"); ok {
+		entry.Exportable = exportable.(bool)
+	}
+	if autoRotatePeriod, ok := data.GetOk("auto_rotate_period"); ok {
+		entry.AutoRotatePeriod = time.Duration(autoRotatePeriod.(int)) * time.Second
+	}
+	if requestsPerSecond, ok := data.GetOk("requests_per_second"); ok {
+		entry.RequestsPerSecond = requestsPerSecond.(float64)
+	}
+	if requestsBurst, ok := data.GetOk("requests_burst"); ok {
+		entry.RequestsBurst = requestsBurst.(int)
+	}
+	if itemsPerSecond, ok := data.GetOk("items_per_second"); ok {
+		entry.ItemsPerSecond = itemsPerSecond.(float64)
+	}
+	if itemsBurst, ok := data.GetOk("items_burst"); ok {
+		entry.ItemsBurst = itemsBurst.(int)
+	}
+}
+
+// validateRole checks the settings of a role about to be written.
+func (b *backend) validateRole(entry *roleEntry) error {
+	if !isValidSaltMode(entry.Mode) {
+		return fmt.Errorf("invalid salt mode")
+	}
+	if entry.KeySource != "" {
+		if _, ok := b.keySources[entry.KeySource]; !ok {
+			return fmt.Errorf("unknown key source %s", entry.KeySource)
+		}
+		if entry.KeyName == "" {
+			return fmt.Errorf("missing key name of the key source")
+		}
+		if err := validateKeyName(entry.KeyName); err != nil {
+			return err
+		}
+		if entry.Salt != "" {
+			return fmt.Errorf("salt can't be set on roles using a key source")
+		}
+		if entry.Exportable || entry.AutoRotatePeriod != 0 {
+			return fmt.Errorf("roles using a key source can be neither exported nor rotated, keys are managed by the source")
+		}
+	} else if entry.KeyName != "" {
+		return fmt.Errorf("key_name requires a key source")
+	}
+	if entry.AutoRotatePeriod != 0 && entry.AutoRotatePeriod < minAutoRotatePeriod {
+		return fmt.Errorf("auto_rotate_period must be zero or at least %s", minAutoRotatePeriod)
+	}
+
+	return entry.rateLimits.validate()
+}
+
+func (b *backend) pathRoleClone() *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("role_name") + "/clone$",
+		Fields: map[string]*framework.FieldSchema{
+			"role_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role to clone",
+			},
+			"new_role_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role to create",
+				Required:    true,
+			},
+			"key_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the key of the key source of the new role. Required to clone a role with a key source, and must differ from the key of the role",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleCloneWrite,
+				Summary:  "Clone a role with a fresh salt.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRoleCloneHelpSyn,
+		HelpDescription: pathRoleCloneHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleCloneWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	err = validateFieldSet(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	roleName := data.Get("role_name").(string)
+	newRoleName := data.Get("new_role_name").(string)
+	if newRoleName == "" {
+		return logical.ErrorResponse("missing new role name"), nil
+	}
+	if err := validateRoleName(newRoleName); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Both roles are locked, in the order of their locks, so the source
+	// isn't changed while it's copied.
+	for _, lock := range locksutil.LocksForKeys(b.roleLocks, []string{roleName, newRoleName}) {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role not found"), nil
+	}
 
-	// Store it
-	jsonEntry, err := logical.StorageEntryJSON("roles/"+roleName, entry)
+	existing, err := b.getRole(ctx, req.Storage, newRoleName)
 	if err != nil {
 		return nil, err
 	}
-	if err := req.Storage.Put(ctx, jsonEntry); err != nil {
+	if existing != nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %s already exists", newRoleName)), nil
+	}
+
+	// Roles using a key source take their salt from another key, so the
+	// clone never computes the sums of the source role.
+	keyName := data.Get("key_name").(string)
+	switch {
+	case role.KeySource == "" && keyName != "":
+		return logical.ErrorResponse("key_name requires a key source"), nil
+	case role.KeySource == "":
+		role.Salt, err = generateSalt()
+		if err != nil {
+			return nil, err
+		}
+	case keyName == "" || keyName == role.KeyName:
+		return logical.ErrorResponse(fmt.Sprintf("role %s takes its salt from key source %s, a new key_name is required", roleName, role.KeySource)), nil
+	default:
+		if err := validateKeyName(keyName); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		role.KeyName = keyName
+	}
+	role.SaltVersion = 1
+	role.PreviousSalts = nil
+	role.LastRotationTime = time.Now().UTC()
+	role.SaltExports = nil
+
+	if err := b.putRole(ctx, req.Storage, newRoleName, role); err != nil {
 		return nil, err
 	}
 
+	b.Logger().Info("cloned role", "role", newRoleName, "source_role", roleName)
 	return nil, nil
 }
 
+func (b *backend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	role, err := b.getRole(ctx, req.Storage, data.Get("role_name").(string))
+	if err != nil {
+		return false, err
+	}
+
+	return role != nil, nil
+}
+
 func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	roleName := data.Get("role_name").(string)
 	if roleName == "" {
@@ -155,11 +750,22 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 }
 
 func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, "roles/"+data.Get("role_name").(string))
+	roleName := data.Get("role_name").(string)
+
+	lock := b.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	err := req.Storage.Delete(ctx, "roles/"+roleName)
 	if err != nil {
 		return nil, err
 	}
+	if err := b.resetUsage(ctx, req.Storage, roleName); err != nil {
+		return nil, err
+	}
+	b.forgetLimiter(roleName)
 
+	b.Logger().Info("deleted role", "role", roleName)
 	return nil, nil
 }
 
@@ -177,9 +783,68 @@ func (b *backend) getRole(ctx context.Context, s logical.Storage, n string) (*ro
 		return nil, err
 	}
 
+	if result.EncryptedSalt != "" {
+		aead, err := b.getSaltCipher(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		if err := decryptRoleSalts(aead, n, &result); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := upgradeRoleEntry(&result); err != nil {
+		return nil, fmt.Errorf("failed to read role %s: %w", n, err)
+	}
+
 	return &result, nil
 }
 
+func (b *backend) putRole(ctx context.Context, s logical.Storage, n string, role *roleEntry) error {
+	aead, err := b.getSaltCipher(ctx, s)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptRoleSalts(aead, n, role)
+	if err != nil {
+		return err
+	}
+	encrypted.SchemaVersion = roleSchemaVersion
+
+	jsonEntry, err := logical.StorageEntryJSON("roles/"+n, encrypted)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, jsonEntry)
+}
+
+// roleNameRegex matches the role names the roles/ paths can address.
+var roleNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("role_name") + "$")
+
+// validateRoleName checks a role name not taken from the path of the
+// request, so roles are never stored at keys the roles/ paths can't reach.
+func validateRoleName(roleName string) error {
+	if !roleNameRegex.MatchString(roleName) {
+		return fmt.Errorf("invalid role name %q", roleName)
+	}
+
+	return nil
+}
+
+// keyNameRegex matches the key names transit accepts.
+var keyNameRegex = regexp.MustCompile("^" + framework.GenericNameRegex("key_name") + "$")
+
+// validateKeyName checks the name of a key in a key source, as it becomes
+// part of the path of the requests sent to the source.
+func validateKeyName(keyName string) error {
+	if !keyNameRegex.MatchString(keyName) || strings.Contains(keyName, "..") {
+		return fmt.Errorf("invalid key name %q", keyName)
+	}
+
+	return nil
+}
+
 func (b *backend) roleLock(roleName string) *locksutil.LockEntry {
 	return locksutil.LockForKey(b.roleLocks, roleName)
 }
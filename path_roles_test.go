@@ -3,6 +3,7 @@ package saltyhash
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -57,12 +58,12 @@ func TestSalty_Role(t *testing.T) {
 			if resp.IsError() {
 				t.Fatalf("bad: got error response: %#v", *resp)
 			}
-			salt, ok := resp.Data["salt"]
-			if !ok {
-				t.Fatal("no salt key found in returned data")
+			if _, ok := resp.Data["salt"]; ok {
+				t.Fatal("salt returned by read")
 			}
-			if salt.(string) != expected {
-				t.Fatalf("mismatched salts: %s != %s", salt.(string), expected)
+			role, err := b.getRole(context.Background(), storage, testRoleName)
+			if err != nil || role == nil || role.Salt != expected {
+				t.Fatalf("bad stored role: %#v, expected salt %s, err: %v", role, expected, err)
 			}
 		}
 	}
@@ -70,11 +71,17 @@ func TestSalty_Role(t *testing.T) {
 	// Test read role
 	doRequest(req, false, false, testSalt)
 
-	// Test update role
+	// Test update role with a changed salt
 	req.Operation = logical.UpdateOperation
 	req.Data = map[string]interface{}{
 		"salt": testUpdatedSalt,
 	}
+	doRequest(req, false, true, "")
+
+	// Test update role with the same salt
+	req.Data = map[string]interface{}{
+		"salt": testSalt,
+	}
 	doRequest(req, true, false, "")
 
 	// Test update role with invalid parameter
@@ -97,7 +104,7 @@ func TestSalty_Role(t *testing.T) {
 
 	// Test read updated role
 	req.Operation = logical.ReadOperation
-	doRequest(req, false, false, testUpdatedSalt)
+	doRequest(req, false, false, testSalt)
 
 	// Test delete role
 	req.Operation = logical.DeleteOperation
@@ -107,3 +114,223 @@ func TestSalty_Role(t *testing.T) {
 	req.Operation = logical.ReadOperation
 	doRequest(req, false, true, "")
 }
+
+func TestSalty_RoleDeleteLock(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data:      map[string]interface{}{"salt": testSalt, "mode": "append"},
+	}, false)
+
+	// Test deletes wait for the role lock, held by concurrent writes
+	lock := b.roleLock(testRoleName)
+	lock.Lock()
+	deleted := make(chan error, 1)
+	go func() {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.DeleteOperation,
+			Path:      "roles/" + testRoleName,
+		})
+		deleted <- err
+	}()
+
+	select {
+	case err := <-deleted:
+		t.Fatalf("role deleted while its lock was held, err: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if role, err := b.getRole(context.Background(), storage, testRoleName); err != nil || role == nil {
+		t.Fatalf("role deleted while its lock was held: %#v, err: %v", role, err)
+	}
+
+	lock.Unlock()
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+	if role, err := b.getRole(context.Background(), storage, testRoleName); err != nil || role != nil {
+		t.Fatalf("role not deleted: %#v, err: %v", role, err)
+	}
+}
+
+func TestSalty_RoleList(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	roles := map[string]map[string]interface{}{
+		"alpha": {"salt": testSalt, "mode": "append", "metadata": "team=a"},
+		"bravo": {"salt": testSalt, "mode": "prepend", "metadata": "team=b"},
+		"delta": {"salt": testSalt, "mode": "append", "metadata": "team=b"},
+	}
+	for name, data := range roles {
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doRequest := func(data map[string]interface{}, expected ...string) map[string]interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.ListOperation,
+			Path:      "roles/",
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.IsError() {
+			t.Fatalf("bad: got error response: %#v", *resp)
+		}
+
+		keys, _ := resp.Data["keys"].([]string)
+		if len(keys) != len(expected) {
+			t.Fatalf("expected keys %v, got %v", expected, keys)
+		}
+		for i := range keys {
+			if keys[i] != expected[i] {
+				t.Fatalf("expected keys %v, got %v", expected, keys)
+			}
+		}
+		keyInfo, _ := resp.Data["key_info"].(map[string]interface{})
+		return keyInfo
+	}
+
+	// Test unfiltered list with key info
+	keyInfo := doRequest(nil, "alpha", "bravo", "delta")
+	info := keyInfo["bravo"].(map[string]interface{})
+	if info["mode"] != "prepend" {
+		t.Fatalf("bad mode in key info: %#v", info)
+	}
+	if _, ok := info["salt"]; ok {
+		t.Fatal("salt must not be exposed in key info")
+	}
+
+	// Test filtering
+	doRequest(map[string]interface{}{"mode": "append"}, "alpha", "delta")
+	doRequest(map[string]interface{}{"metadata": "team=b"}, "bravo", "delta")
+	doRequest(map[string]interface{}{"mode": "append", "metadata": "team=b"}, "delta")
+	doRequest(map[string]interface{}{"metadata": "team=c"})
+
+	// Test pagination
+	doRequest(map[string]interface{}{"limit": 2}, "alpha", "bravo")
+	doRequest(map[string]interface{}{"after": "bravo", "limit": 2}, "delta")
+	doRequest(map[string]interface{}{"after": "alpha", "mode": "append"}, "delta")
+}
+
+func TestSalty_RoleClone(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt":     testSalt,
+			"mode":     "prepend",
+			"metadata": "team=a",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName + "/clone",
+		Data: map[string]interface{}{
+			"new_role_name": "cloned",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+	}
+
+	role, err := b.getRole(context.Background(), storage, "cloned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role == nil || role.Mode != "prepend" || role.Metadata["team"] != "a" {
+		t.Fatalf("bad cloned role: %#v", role)
+	}
+	if role.Salt == "" || role.Salt == testSalt {
+		t.Fatalf("cloned role must get a fresh salt, got %q", role.Salt)
+	}
+
+	// Test clone over an existing role
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err == nil && !resp.IsError() {
+		t.Fatal("bad: got no error response when cloning over existing role")
+	}
+
+	// Test clone of a missing role
+	req.Path = "roles/missing/clone"
+	req.Data["new_role_name"] = "other"
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err == nil && !resp.IsError() {
+		t.Fatal("bad: got no error response when cloning missing role")
+	}
+
+	// Test clone to names the role paths can't address
+	req.Path = "roles/" + testRoleName + "/clone"
+	for _, name := range []string{"a/b", "../x", "-x", "x y"} {
+		req.Data["new_role_name"] = name
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err == nil && !resp.IsError() {
+			t.Fatalf("bad: got no error response when cloning to %q", name)
+		}
+	}
+	if keys, err := storage.List(context.Background(), "roles/"); err != nil || len(keys) != 2 {
+		t.Fatalf("bad: roles stored: %v, err: %v", keys, err)
+	}
+}
+
+func TestSalty_RoleCreateUpdate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	doRequest := func(operation logical.Operation, data map[string]interface{}, errExpected bool) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: operation,
+			Path:      "roles/" + testRoleName,
+			Data:      data,
+		})
+		if errExpected {
+			if err == nil && !resp.IsError() {
+				t.Fatalf("bad: got no error response when error expected")
+			}
+			return
+		}
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+	}
+
+	// Test create requires the mode and the salt
+	doRequest(logical.CreateOperation, map[string]interface{}{"salt": testSalt}, true)
+	doRequest(logical.CreateOperation, map[string]interface{}{"mode": "append"}, true)
+	doRequest(logical.CreateOperation, map[string]interface{}{"salt": testSalt, "mode": "append", "metadata": "team=billing"}, false)
+	doRequest(logical.CreateOperation, map[string]interface{}{"salt": testSalt, "mode": "append"}, true)
+
+	// Test update is partial and keeps immutable fields
+	doRequest(logical.UpdateOperation, map[string]interface{}{"mode": "prepend"}, false)
+	doRequest(logical.UpdateOperation, map[string]interface{}{"salt": testUpdatedSalt}, true)
+	doRequest(logical.UpdateOperation, map[string]interface{}{"template": "billing"}, true)
+
+	role, err := b.getRole(context.Background(), storage, testRoleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Salt != testSalt || role.Mode != "prepend" || role.Metadata["team"] != "billing" {
+		t.Fatalf("bad updated role: %#v", role)
+	}
+}
@@ -0,0 +1,124 @@
+package saltyhash
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathRotateHelpSyn  = `Rotate the salt of a role`
+	pathRotateHelpDesc = `Replaces the salt of the role with a freshly generated one under the next
+version. Previous salt versions are kept, so sums computed before the rotation
+can still be reproduced by version.`
+)
+
+// errKeySourceRotation is returned when rotating a role using a key source,
+// which keys are rotated in the source.
+var errKeySourceRotation = errors.New("roles using a key source are rotated in the source")
+
+func (b *backend) pathRotate() *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("role_name") + "/rotate$",
+		Fields: map[string]*framework.FieldSchema{
+			"role_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRotateWrite,
+				Summary:  "Rotate the salt of a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"salt_version": 2,
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathRotateHelpSyn,
+		HelpDescription: pathRotateHelpDesc,
+	}
+}
+
+func (b *backend) pathRotateWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role_name").(string)
+
+	role, err := b.rotateRole(ctx, req.Storage, roleName, false)
+	if err == errKeySourceRotation {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role not found"), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"salt_version": role.SaltVersion,
+		},
+	}, nil
+}
+
+// rotateRole rotates the salt of the role under its lock. If onlyDue is set
+// the role is left untouched unless its automatic rotation is due. The
+// returned role is nil if it doesn't exist.
+func (b *backend) rotateRole(ctx context.Context, s logical.Storage, roleName string, onlyDue bool) (*roleEntry, error) {
+	lock := b.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := b.getRole(ctx, s, roleName)
+	if err != nil || role == nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if onlyDue && !role.rotationDue(now) {
+		return role, nil
+	}
+	if role.KeySource != "" {
+		return nil, errKeySourceRotation
+	}
+
+	if err := role.rotate(now); err != nil {
+		return nil, err
+	}
+	if err := b.putRole(ctx, s, roleName, role); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("rotated role salt", "role", roleName, "salt_version", role.SaltVersion)
+	return role, nil
+}
+
+// autoRotateRoles rotates the salts of every role which automatic rotation is
+// due. Failures are logged and don't prevent other roles from being rotated.
+func (b *backend) autoRotateRoles(ctx context.Context, s logical.Storage) error {
+	roleNames, err := s.List(ctx, "roles/")
+	if err != nil {
+		return err
+	}
+
+	for _, roleName := range roleNames {
+		if _, err := b.rotateRole(ctx, s, roleName, true); err != nil {
+			b.Logger().Error("failed to auto-rotate role salt", "role", roleName, "error", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package saltyhash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSalty_Rotate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	roleReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt": testSalt,
+			"mode": "append",
+		},
+	}
+	handleRequest(t, b, storage, roleReq, false)
+
+	hashReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      hashPath + "/sha2-256",
+		Data: map[string]interface{}{
+			"input": testSecret,
+		},
+	}
+	before := handleRequest(t, b, storage, hashReq, false)
+	if before.Data["salt_version"] != 1 {
+		t.Fatalf("bad salt version: %v", before.Data["salt_version"])
+	}
+
+	// Test manual rotation
+	resp := handleRequest(t, b, storage, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName + "/rotate",
+	}, false)
+	if resp.Data["salt_version"] != 2 {
+		t.Fatalf("bad salt version after rotation: %v", resp.Data["salt_version"])
+	}
+
+	after := handleRequest(t, b, storage, hashReq, false)
+	if after.Data["salt_version"] != 2 || after.Data["sum"] == before.Data["sum"] {
+		t.Fatalf("hash must use the rotated salt: %#v", after.Data)
+	}
+
+	role, err := b.getRole(context.Background(), storage, testRoleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if salt, err := role.saltForVersion(1); err != nil || salt != testSalt {
+		t.Fatalf("previous salt must be kept, got %q: %v", salt, err)
+	}
+
+	// Test rotation of a missing role
+	handleRequest(t, b, storage, &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/missing/rotate",
+	}, true)
+
+	// Test auto rotate period validation
+	roleReq.Data = map[string]interface{}{
+		"auto_rotate_period": "10m",
+	}
+	handleRequest(t, b, storage, roleReq, true)
+
+	roleReq.Data["auto_rotate_period"] = "24h"
+	handleRequest(t, b, storage, roleReq, false)
+
+	// Test periodic rotation is skipped until due
+	if err := b.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	role, err = b.getRole(context.Background(), storage, testRoleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.SaltVersion != 2 {
+		t.Fatalf("rotation must not happen before due, got salt version %d", role.SaltVersion)
+	}
+
+	// Test periodic rotation once due
+	role.LastRotationTime = time.Now().Add(-25 * time.Hour)
+	if err := b.putRole(context.Background(), storage, testRoleName, role); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	role, err = b.getRole(context.Background(), storage, testRoleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.SaltVersion != 3 || time.Since(role.LastRotationTime) > time.Minute {
+		t.Fatalf("rotation must happen once due, got %#v", role)
+	}
+}
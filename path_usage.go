@@ -0,0 +1,238 @@
+package saltyhash
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathUsageHelpSyn  = `Read or reset the usage counters of a role`
+	pathUsageHelpDesc = `Returns the number of inputs hashed with the role through the hash and
+hash_batch endpoints and the time it was last used. Counters are aggregated in
+memory and flushed to storage periodically and when the mount is unloaded.
+Requests served by performance standbys and replicated mounts of performance
+secondaries aren't counted. Deleting resets the counters.`
+)
+
+// roleUsage holds the usage counters of a role. The same type holds both the
+// flushed totals and the pending in-memory deltas.
+type roleUsage struct {
+	TotalHashes     uint64    `json:"total_hashes"`
+	TotalBatchItems uint64    `json:"total_batch_items"`
+	LastUsed        time.Time `json:"last_used"`
+}
+
+func (u *roleUsage) add(delta *roleUsage) {
+	u.TotalHashes += delta.TotalHashes
+	u.TotalBatchItems += delta.TotalBatchItems
+	if delta.LastUsed.After(u.LastUsed) {
+		u.LastUsed = delta.LastUsed
+	}
+}
+
+func (u *roleUsage) ToResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"total_hashes":      u.TotalHashes,
+		"total_batch_items": u.TotalBatchItems,
+		"last_used":         u.LastUsed,
+	}
+}
+
+func (b *backend) pathUsage() *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("role_name") + "/usage$",
+		Fields: map[string]*framework.FieldSchema{
+			"role_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathUsageRead,
+				Summary:  "Read the usage of a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"total_hashes":      1024,
+								"total_batch_items": 1000,
+								"last_used":         "2020-07-20T10:00:00Z",
+							},
+						},
+					}},
+				},
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathUsageDelete,
+				Summary:  "Reset the usage of a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusNoContent: {{Description: "No content"}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathUsageHelpSyn,
+		HelpDescription: pathUsageHelpDesc,
+	}
+}
+
+func (b *backend) pathUsageRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role_name").(string)
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role not found"), nil
+	}
+
+	usage, err := b.getUsage(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: usage.ToResponseData(),
+	}, nil
+}
+
+func (b *backend) pathUsageDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role_name").(string)
+
+	if err := b.resetUsage(ctx, req.Storage, roleName); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("reset role usage", "role", roleName)
+	return nil, nil
+}
+
+// recordUsage adds the hashed inputs to the pending usage of the role. Usage
+// isn't recorded on nodes which can't write storage, as it would never be
+// flushed.
+func (b *backend) recordUsage(roleName string, hashes, batchItems int) {
+	if !b.storageWritable() {
+		return
+	}
+
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+
+	delta, ok := b.usage[roleName]
+	if !ok {
+		delta = &roleUsage{}
+		b.usage[roleName] = delta
+	}
+
+	delta.add(&roleUsage{
+		TotalHashes:     uint64(hashes),
+		TotalBatchItems: uint64(batchItems),
+		LastUsed:        time.Now().UTC(),
+	})
+}
+
+// getUsage returns the flushed usage of the role merged with the pending one.
+func (b *backend) getUsage(ctx context.Context, s logical.Storage, roleName string) (*roleUsage, error) {
+	// Hold off flushes so the pending usage isn't counted twice.
+	b.usageFlushLock.Lock()
+	defer b.usageFlushLock.Unlock()
+
+	usage, err := b.getStoredUsage(ctx, s, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	b.usageLock.Lock()
+	if delta, ok := b.usage[roleName]; ok {
+		usage.add(delta)
+	}
+	b.usageLock.Unlock()
+
+	return usage, nil
+}
+
+func (b *backend) getStoredUsage(ctx context.Context, s logical.Storage, roleName string) (*roleUsage, error) {
+	entry, err := s.Get(ctx, "usage/"+roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage roleUsage
+	if entry != nil {
+		if err := entry.DecodeJSON(&usage); err != nil {
+			return nil, err
+		}
+	}
+
+	return &usage, nil
+}
+
+// flushUsage adds the pending usage to the stored one. Deltas which fail to
+// be flushed are kept for the next flush.
+func (b *backend) flushUsage(ctx context.Context, s logical.Storage) error {
+	b.usageFlushLock.Lock()
+	defer b.usageFlushLock.Unlock()
+
+	b.usageLock.Lock()
+	pending := b.usage
+	b.usage = make(map[string]*roleUsage)
+	b.usageLock.Unlock()
+
+	var retErr error
+	for roleName, delta := range pending {
+		if err := b.flushRoleUsage(ctx, s, roleName, delta); err != nil {
+			b.Logger().Error("failed to flush role usage", "role", roleName, "error", err)
+			retErr = err
+
+			b.usageLock.Lock()
+			if newer, ok := b.usage[roleName]; ok {
+				delta.add(newer)
+			}
+			b.usage[roleName] = delta
+			b.usageLock.Unlock()
+		}
+	}
+
+	return retErr
+}
+
+func (b *backend) flushRoleUsage(ctx context.Context, s logical.Storage, roleName string, delta *roleUsage) error {
+	// Don't resurrect the usage of roles deleted since it was recorded.
+	role, err := b.getRole(ctx, s, roleName)
+	if err != nil || role == nil {
+		return err
+	}
+
+	usage, err := b.getStoredUsage(ctx, s, roleName)
+	if err != nil {
+		return err
+	}
+	usage.add(delta)
+
+	entry, err := logical.StorageEntryJSON("usage/"+roleName, usage)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// resetUsage drops both the stored and the pending usage of the role.
+func (b *backend) resetUsage(ctx context.Context, s logical.Storage, roleName string) error {
+	b.usageFlushLock.Lock()
+	defer b.usageFlushLock.Unlock()
+
+	b.usageLock.Lock()
+	delete(b.usage, roleName)
+	b.usageLock.Unlock()
+
+	return s.Delete(ctx, "usage/"+roleName)
+}
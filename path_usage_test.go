@@ -0,0 +1,116 @@
+package saltyhash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSalty_Usage(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for _, name := range []string{testRoleName, "unused"} {
+		handleRequest(t, b, storage, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Data: map[string]interface{}{
+				"salt": testSalt,
+				"mode": "append",
+			},
+		}, false)
+	}
+
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      hashPath + "/sha1",
+		Data:      map[string]interface{}{"input": testSecret},
+	}, false)
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      hashBatchPath + "/sha1",
+		Data:      map[string]interface{}{"input": []string{testSecret, testSecret}},
+	}, false)
+
+	usageReq := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "roles/" + testRoleName + "/usage",
+	}
+	checkUsage := func(hashes, batchItems uint64) {
+		t.Helper()
+		resp := handleRequest(t, b, storage, usageReq, false)
+		if resp.Data["total_hashes"] != hashes || resp.Data["total_batch_items"] != batchItems {
+			t.Fatalf("bad usage: %#v", resp.Data)
+		}
+	}
+
+	// Test pending usage is reported before flush
+	checkUsage(1, 2)
+	if stored, err := b.getStoredUsage(context.Background(), storage, testRoleName); err != nil || stored.TotalHashes != 0 {
+		t.Fatalf("usage must not be stored before flush, got %#v: %v", stored, err)
+	}
+
+	// Test flush by the periodic function
+	if err := b.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := b.getStoredUsage(context.Background(), storage, testRoleName)
+	if err != nil || stored.TotalHashes != 1 || stored.TotalBatchItems != 2 {
+		t.Fatalf("bad stored usage: %#v: %v", stored, err)
+	}
+	checkUsage(1, 2)
+
+	// Test flushed and pending usage are merged
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      hashPath + "/sha1",
+		Data:      map[string]interface{}{"input": testSecret},
+	}, false)
+	checkUsage(2, 2)
+
+	// Test pending usage is flushed when the mount is unloaded
+	b.Cleanup(context.Background())
+	stored, err = b.getStoredUsage(context.Background(), storage, testRoleName)
+	if err != nil || stored.TotalHashes != 2 || stored.TotalBatchItems != 2 {
+		t.Fatalf("bad stored usage after cleanup: %#v: %v", stored, err)
+	}
+
+	// Test usage isn't recorded on nodes which can't flush it
+	b.System().(*logical.StaticSystemView).ReplicationStateVal = consts.ReplicationPerformanceStandby
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      hashPath + "/sha1",
+		Data:      map[string]interface{}{"input": testSecret},
+	}, false)
+	if len(b.usage) != 0 {
+		t.Fatalf("usage recorded on a performance standby: %#v", b.usage)
+	}
+	b.System().(*logical.StaticSystemView).ReplicationStateVal = 0
+	checkUsage(2, 2)
+
+	// Test stale roles listing
+	resp := handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "roles/",
+		Data:      map[string]interface{}{"stale_after": "1h"},
+	}, false)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "unused" {
+		t.Fatalf("bad stale roles: %v", keys)
+	}
+	info := resp.Data["key_info"].(map[string]interface{})["unused"].(map[string]interface{})
+	if !info["last_used"].(time.Time).IsZero() {
+		t.Fatalf("unused role must have no last use: %#v", info)
+	}
+
+	// Test reset
+	usageReq.Operation = logical.DeleteOperation
+	handleRequest(t, b, storage, usageReq, false)
+	usageReq.Operation = logical.ReadOperation
+	checkUsage(0, 0)
+
+	// Test usage of a missing role
+	usageReq.Path = "roles/missing/usage"
+	handleRequest(t, b, storage, usageReq, true)
+}
@@ -0,0 +1,199 @@
+package saltyhash
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	pathWrappingKeyHelpSyn  = `Returns the public keys used to move salts in and out of this backend`
+	pathWrappingKeyHelpDesc = `Returns the public part of the mount-specific RSA wrapping key, which must
+be used with RSA-OAEP (SHA-256) to wrap salts sent to the import endpoints, and
+the public part of the ed25519 key which signs the bundles produced by export.`
+
+	mountKeysStoragePath = "config/mount_keys"
+	wrappingKeyBits      = 4096
+)
+
+// mountKeys holds the keys which are generated once per mount and are used
+// to transfer salts between mounts without exposing them in plaintext.
+type mountKeys struct {
+	WrappingKey []byte `json:"wrapping_key"`
+	SigningKey  []byte `json:"signing_key"`
+
+	wrappingKey *rsa.PrivateKey
+	signingKey  ed25519.PrivateKey
+}
+
+func (k *mountKeys) decode() error {
+	wrappingKey, err := x509.ParsePKCS1PrivateKey(k.WrappingKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse wrapping key: %w", err)
+	}
+	if len(k.SigningKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid signing key size %d", len(k.SigningKey))
+	}
+
+	k.wrappingKey = wrappingKey
+	k.signingKey = k.SigningKey
+	return nil
+}
+
+// unwrap decrypts the ciphertext wrapped with the public wrapping key.
+func (k *mountKeys) unwrap(ciphertext []byte) ([]byte, error) {
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, k.wrappingKey, ciphertext, nil)
+}
+
+func (k *mountKeys) signingPublicKey() ed25519.PublicKey {
+	return k.signingKey.Public().(ed25519.PublicKey)
+}
+
+func (b *backend) pathWrappingKey() *framework.Path {
+	return &framework.Path{
+		Pattern: "wrapping_key$",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathWrappingKeyRead,
+				Summary:  "Read the public keys of the mount.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "OK",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"public_key":         "-----BEGIN PUBLIC KEY-----\n...\n-----END PUBLIC KEY-----\n",
+								"signing_public_key": "cHVibGljIGtleQ==",
+							},
+						},
+					}},
+				},
+			},
+		},
+
+		HelpSynopsis:    pathWrappingKeyHelpSyn,
+		HelpDescription: pathWrappingKeyHelpDesc,
+	}
+}
+
+func (b *backend) pathWrappingKeyRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	keys, err := b.getMountKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&keys.wrappingKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": string(pem.EncodeToMemory(&pem.Block{
+				Type:  "PUBLIC KEY",
+				Bytes: publicKey,
+			})),
+			"signing_public_key": base64.StdEncoding.EncodeToString(keys.signingPublicKey()),
+		},
+	}, nil
+}
+
+// getMountKeys returns the keys of the mount, generating and persisting them
+// on first use.
+func (b *backend) getMountKeys(ctx context.Context, s logical.Storage) (*mountKeys, error) {
+	b.mountKeysLock.Lock()
+	defer b.mountKeysLock.Unlock()
+
+	if b.mountKeys != nil {
+		return b.mountKeys, nil
+	}
+
+	var keys mountKeys
+	entry, err := s.Get(ctx, mountKeysStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil {
+		if err := entry.DecodeJSON(&keys); err != nil {
+			return nil, err
+		}
+	} else {
+		wrappingKey, err := rsa.GenerateKey(rand.Reader, wrappingKeyBits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate wrapping key: %w", err)
+		}
+		_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+
+		keys.WrappingKey = x509.MarshalPKCS1PrivateKey(wrappingKey)
+		keys.SigningKey = signingKey
+
+		jsonEntry, err := logical.StorageEntryJSON(mountKeysStoragePath, &keys)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Put(ctx, jsonEntry); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := keys.decode(); err != nil {
+		return nil, err
+	}
+
+	b.mountKeys = &keys
+	return b.mountKeys, nil
+}
+
+// wrapForPublicKey wraps the plaintext with the given PEM-encoded RSA public
+// key, as expected by the import endpoints of the key owner.
+func wrapForPublicKey(publicKeyPEM string, plaintext []byte) ([]byte, error) {
+	publicKey, err := parseWrappingPublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, plaintext, nil)
+}
+
+// parseWrappingPublicKey parses a PEM-encoded RSA wrapping public key, as
+// returned by the wrapping_key endpoint.
+func parseWrappingPublicKey(publicKeyPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("public key is not PEM-encoded")
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	publicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an RSA key")
+	}
+
+	return publicKey, nil
+}
+
+// wrappingKeyFingerprint returns the hex-encoded SHA-256 sum of the
+// PKCS #1 encoding of the wrapping public key, which identifies a mount
+// whatever the PEM formatting of its key.
+func wrappingKeyFingerprint(publicKey *rsa.PublicKey) string {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(publicKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,107 @@
+package saltyhash
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// roleLimiter holds the token buckets limiting the hash requests of a role,
+// along with the role settings they were built from.
+type roleLimiter struct {
+	limits   rateLimits
+	requests *rate.Limiter
+	items    *rate.Limiter
+}
+
+// rateLimits are the rate limit settings of a role.
+type rateLimits struct {
+	RequestsPerSecond float64 `json:"requests_per_second" mapstructure:"requests_per_second"`
+	RequestsBurst     int     `json:"requests_burst" mapstructure:"requests_burst"`
+	ItemsPerSecond    float64 `json:"items_per_second" mapstructure:"items_per_second"`
+	ItemsBurst        int     `json:"items_burst" mapstructure:"items_burst"`
+}
+
+func (l rateLimits) enabled() bool {
+	return l.RequestsPerSecond > 0 || l.ItemsPerSecond > 0
+}
+
+func (l rateLimits) validate() error {
+	if l.RequestsPerSecond < 0 || l.ItemsPerSecond < 0 {
+		return fmt.Errorf("rate limits must be non-negative")
+	}
+	if l.RequestsBurst < 0 || l.ItemsBurst < 0 {
+		return fmt.Errorf("rate limit bursts must be non-negative")
+	}
+
+	return nil
+}
+
+// newBucket returns the token bucket of the given rate, unlimited if zero.
+// The burst defaults to the rate rounded up.
+func newBucket(perSecond float64, burst int) *rate.Limiter {
+	if perSecond == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	if burst == 0 {
+		burst = int(math.Ceil(perSecond))
+	}
+
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// allowHash takes a request and the given number of items from the token
+// buckets of the role, or none of them if either bucket is exhausted.
+func (b *backend) allowHash(roleName string, role *roleEntry, items int) error {
+	if !role.rateLimits.enabled() {
+		return nil
+	}
+
+	b.limitersLock.Lock()
+	limiter, ok := b.limiters[roleName]
+	if !ok || limiter.limits != role.rateLimits {
+		limiter = &roleLimiter{
+			limits:   role.rateLimits,
+			requests: newBucket(role.RequestsPerSecond, role.RequestsBurst),
+			items:    newBucket(role.ItemsPerSecond, role.ItemsBurst),
+		}
+		b.limiters[roleName] = limiter
+	}
+	b.limitersLock.Unlock()
+
+	now := b.now()
+
+	requests := limiter.requests.ReserveN(now, 1)
+	if !requests.OK() || requests.DelayFrom(now) > 0 {
+		requests.CancelAt(now)
+		return fmt.Errorf("rate limit of %v requests per second exceeded for role %s", role.RequestsPerSecond, roleName)
+	}
+
+	if items > limiter.items.Burst() && limiter.items.Limit() != rate.Inf {
+		requests.CancelAt(now)
+		return fmt.Errorf("batch of %d items exceeds the burst of %d items of role %s", items, limiter.items.Burst(), roleName)
+	}
+
+	itemsReservation := limiter.items.ReserveN(now, items)
+	if !itemsReservation.OK() || itemsReservation.DelayFrom(now) > 0 {
+		itemsReservation.CancelAt(now)
+		requests.CancelAt(now)
+		return fmt.Errorf("rate limit of %v items per second exceeded for role %s", role.ItemsPerSecond, roleName)
+	}
+
+	return nil
+}
+
+// forgetLimiter drops the token buckets of the role.
+func (b *backend) forgetLimiter(roleName string) {
+	b.limitersLock.Lock()
+	delete(b.limiters, roleName)
+	b.limitersLock.Unlock()
+}
+
+// wallClock is the default clock of the backend, replaced in tests.
+func wallClock() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,194 @@
+package saltyhash
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeClock is a manually advanced clock for the backend.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestSalty_RateLimit(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	clock := &fakeClock{now: time.Now()}
+	b.now = clock.Now
+
+	roleReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt":                testSalt,
+			"mode":                "append",
+			"requests_per_second": 2,
+			"items_per_second":    4,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), roleReq)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+	}
+
+	hashReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      hashPath + "/sha1",
+		Data:      map[string]interface{}{"input": testSecret},
+	}
+	batchReq := &logical.Request{
+		Storage:   storage,
+		Operation: logical.UpdateOperation,
+		Path:      hashBatchPath + "/sha1",
+		Data:      map[string]interface{}{"input": []string{testSecret, testSecret, testSecret}},
+	}
+
+	doRequest := func(req *logical.Request, limited bool) {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), req)
+		if !limited {
+			if err != nil || resp.IsError() {
+				t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+			}
+			return
+		}
+
+		coded, ok := err.(logical.HTTPCodedError)
+		if !ok || coded.Code() != http.StatusTooManyRequests || !resp.IsError() {
+			t.Fatalf("expected rate limited response, got resp: %#v, err: %v", resp, err)
+		}
+	}
+
+	// Test requests limit, the burst defaults to the rate
+	doRequest(hashReq, false)
+	doRequest(hashReq, false)
+	doRequest(hashReq, true)
+
+	// Test the bucket refills with time
+	clock.Advance(500 * time.Millisecond)
+	doRequest(hashReq, false)
+	doRequest(hashReq, true)
+
+	// Test items limit
+	clock.Advance(time.Second)
+	doRequest(batchReq, false)
+	doRequest(batchReq, true)
+
+	// Test the request rejected by the items limit didn't take a request token
+	doRequest(hashReq, false)
+
+	// Test batches larger than the items burst are always rejected
+	clock.Advance(time.Minute)
+	batchReq.Data["input"] = []string{testSecret, testSecret, testSecret, testSecret, testSecret}
+	doRequest(batchReq, true)
+
+	// Test changed limits apply immediately
+	roleReq.Data = map[string]interface{}{
+		"requests_per_second": 0,
+		"items_per_second":    0,
+	}
+	resp, err = b.HandleRequest(context.Background(), roleReq)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+	}
+	for i := 0; i < 10; i++ {
+		doRequest(batchReq, false)
+	}
+
+	// Test invalid limits
+	roleReq.Data = map[string]interface{}{
+		"items_per_second": -1,
+	}
+	resp, err = b.HandleRequest(context.Background(), roleReq)
+	if err == nil && !resp.IsError() {
+		t.Fatal("bad: got no error response for negative rate limit")
+	}
+}
+
+func TestSalty_RateLimitInvalidRequests(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	clock := &fakeClock{now: time.Now()}
+	b.now = clock.Now
+
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt":                testSalt,
+			"mode":                "append",
+			"requests_per_second": 1,
+			"items_per_second":    2,
+		},
+	}, false)
+
+	doRequest := func(path string, data map[string]interface{}, status int) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+		})
+		switch status {
+		case http.StatusOK:
+			if err != nil || resp.IsError() {
+				t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+			}
+		case http.StatusTooManyRequests:
+			if coded, ok := err.(logical.HTTPCodedError); !ok || coded.Code() != status {
+				t.Fatalf("expected rate limited response, got resp: %#v, err: %v", resp, err)
+			}
+		default:
+			if err == nil && !resp.IsError() {
+				t.Fatalf("expected error response, got resp: %#v", resp)
+			}
+			if coded, ok := err.(logical.HTTPCodedError); ok && coded.Code() == http.StatusTooManyRequests {
+				t.Fatalf("invalid request rate limited: resp: %#v, err: %v", resp, err)
+			}
+		}
+		return resp
+	}
+	rehashPath := "rehash/" + testRoleName
+
+	// Test invalid requests to every endpoint don't take from the buckets
+	for i := 0; i < 3; i++ {
+		doRequest(hashPath+"/sha1", map[string]interface{}{"input": "foobar"}, http.StatusBadRequest)
+		doRequest(hashPath+"/shabracadabra", map[string]interface{}{"input": testSecret}, http.StatusBadRequest)
+		doRequest(hashBatchPath+"/sha1", map[string]interface{}{"input": []string{testSecret, "foobar"}}, http.StatusBadRequest)
+		doRequest(rehashPath, map[string]interface{}{"input": testSecret, "sum": "nothex", "salt_version": 1, "algorithm": "sha1"}, http.StatusBadRequest)
+		doRequest(rehashPath, map[string]interface{}{
+			"batch_input": []interface{}{map[string]interface{}{"input": "foobar", "sum": "00", "salt_version": 1, "algorithm": "sha1"}},
+		}, http.StatusOK)
+	}
+	sum := doRequest(hashPath+"/sha1", map[string]interface{}{"input": testSecret}, http.StatusOK).Data["sum"]
+
+	// Test rehash is rate limited as well
+	rehashData := map[string]interface{}{"input": testSecret, "sum": sum, "salt_version": 1, "algorithm": "sha1"}
+	doRequest(rehashPath, rehashData, http.StatusTooManyRequests)
+	clock.Advance(time.Second)
+	doRequest(rehashPath, rehashData, http.StatusOK)
+
+	// Test only the valid items of rehash batches take from the items bucket,
+	// four items exceeding its burst
+	clock.Advance(time.Second)
+	item := map[string]interface{}{"input": testSecret, "sum": sum}
+	invalidItem := map[string]interface{}{"input": "foobar", "sum": sum}
+	doRequest(rehashPath, map[string]interface{}{
+		"salt_version": 1,
+		"algorithm":    "sha1",
+		"batch_input":  []interface{}{item, invalidItem, item, invalidItem},
+	}, http.StatusOK)
+}
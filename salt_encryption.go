@@ -0,0 +1,293 @@
+package saltyhash
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	saltKeyStoragePath = "config/salt_key"
+	saltKeyLength      = 32
+)
+
+// saltKeyEntry is the mount-level key envelope-encrypting role salts in
+// storage, so role entries read on their own don't reveal them. Unless the
+// config names a transit key to wrap it with, the key is kept in plaintext in
+// the same storage, seal-wrapped only where the seal supports it, so a dump of
+// the whole mount storage does reveal the salts.
+type saltKeyEntry struct {
+	Key []byte `json:"key,omitempty"`
+
+	// WrappedKey is the key encrypted by the TransitKey of the transit mount
+	// of the config, replacing Key, see wrapSaltKey.
+	WrappedKey string `json:"wrapped_key,omitempty"`
+	TransitKey string `json:"transit_key,omitempty"`
+}
+
+// getSaltCipher returns the AEAD of the salt key, generating and persisting
+// the key on first use.
+func (b *backend) getSaltCipher(ctx context.Context, s logical.Storage) (cipher.AEAD, error) {
+	b.saltCipherLock.Lock()
+	defer b.saltCipherLock.Unlock()
+
+	if b.saltCipher != nil {
+		return b.saltCipher, nil
+	}
+
+	config, err := b.getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.loadSaltCipher(ctx, s, config)
+}
+
+// wrapSaltKey wraps the salt key with the transit key of the config, if not
+// already, and caches its AEAD. It's called on config writes, before the
+// config is stored, so a transit key that can't be used is refused.
+func (b *backend) wrapSaltKey(ctx context.Context, s logical.Storage, config *configEntry) error {
+	b.saltCipherLock.Lock()
+	defer b.saltCipherLock.Unlock()
+
+	_, err := b.loadSaltCipher(ctx, s, config)
+	return err
+}
+
+// loadSaltCipher reads the salt key, unwrapping it through transit if it was
+// wrapped, and caches its AEAD. Keys stored in plaintext, or wrapped with
+// another transit key than the one of the config, are wrapped again with it.
+// The caller must hold saltCipherLock.
+func (b *backend) loadSaltCipher(ctx context.Context, s logical.Storage, config *configEntry) (cipher.AEAD, error) {
+	var stored saltKeyEntry
+	entry, err := s.Get(ctx, saltKeyStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if err := entry.DecodeJSON(&stored); err != nil {
+			return nil, err
+		}
+	}
+
+	var key []byte
+	switch {
+	case entry == nil:
+		key = make([]byte, saltKeyLength)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate salt key: %w", err)
+		}
+	case stored.WrappedKey != "":
+		key, err = transitDecrypt(ctx, config, stored.TransitKey, stored.WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap salt key with transit key %s: %w", stored.TransitKey, err)
+		}
+	default:
+		key = stored.Key
+	}
+
+	// Keys already stored are only wrapped again where storage is writable,
+	// the primary rewraps them for the other nodes.
+	rewrap := config.SaltKeyTransitKey != "" && stored.TransitKey != config.SaltKeyTransitKey
+	if entry == nil || (rewrap && b.storageWritable()) {
+		stored = saltKeyEntry{Key: key}
+		if config.SaltKeyTransitKey != "" {
+			wrapped, err := transitEncrypt(ctx, config, config.SaltKeyTransitKey, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to wrap salt key with transit key %s: %w", config.SaltKeyTransitKey, err)
+			}
+			stored = saltKeyEntry{WrappedKey: wrapped, TransitKey: config.SaltKeyTransitKey}
+		}
+
+		jsonEntry, err := logical.StorageEntryJSON(saltKeyStoragePath, &stored)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Put(ctx, jsonEntry); err != nil {
+			return nil, err
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	b.saltCipher = aead
+	return aead, nil
+}
+
+// transitEncrypt encrypts the plaintext with the named key of the transit
+// mount of the config, returning the transit ciphertext.
+func transitEncrypt(ctx context.Context, config *configEntry, keyName string, plaintext []byte) (string, error) {
+	secret, err := transitWrite(ctx, config, "encrypt", keyName, map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, ok := secret.Data["ciphertext"].(string)
+	if !ok || ciphertext == "" {
+		return "", fmt.Errorf("no ciphertext returned by transit")
+	}
+
+	return ciphertext, nil
+}
+
+func transitDecrypt(ctx context.Context, config *configEntry, keyName string, ciphertext string) ([]byte, error) {
+	secret, err := transitWrite(ctx, config, "decrypt", keyName, map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("no plaintext returned by transit")
+	}
+
+	return base64.StdEncoding.DecodeString(plaintext)
+}
+
+func transitWrite(ctx context.Context, config *configEntry, operation, keyName string, body map[string]interface{}) (*api.Secret, error) {
+	client, mountPath, err := newTransitClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	req := client.NewRequest(http.MethodPost, "/v1/"+path.Join(mountPath, operation, url.PathEscape(keyName)))
+	if err := req.SetJSONBody(body); err != nil {
+		return nil, err
+	}
+	resp, err := client.RawRequestWithContext(ctx, req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("empty response from transit")
+	}
+
+	return secret, nil
+}
+
+// seal encrypts the plaintext bound to the additional data, prefixed by its
+// random nonce.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// encryptSalt seals the salt bound to the role name, so ciphertexts can't be
+// swapped between roles in storage.
+func encryptSalt(aead cipher.AEAD, roleName string, salt string) (string, error) {
+	sealed, err := seal(aead, []byte(salt), []byte(roleName))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSalt(aead cipher.AEAD, roleName string, encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+
+	salt, err := open(aead, sealed, []byte(roleName))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt salt of role %s: %w", roleName, err)
+	}
+
+	return string(salt), nil
+}
+
+// encryptRoleSalts returns a copy of the role with every salt replaced by its
+// encrypted form, as written to storage.
+func encryptRoleSalts(aead cipher.AEAD, roleName string, role *roleEntry) (*roleEntry, error) {
+	encrypted := *role
+	encrypted.Salt = ""
+	encrypted.PreviousSalts = nil
+
+	var err error
+	encrypted.EncryptedSalt, err = encryptSalt(aead, roleName, role.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(role.PreviousSalts) > 0 {
+		encrypted.EncryptedPreviousSalts = make(map[int]string, len(role.PreviousSalts))
+	}
+	for version, salt := range role.PreviousSalts {
+		encrypted.EncryptedPreviousSalts[version], err = encryptSalt(aead, roleName, salt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &encrypted, nil
+}
+
+// decryptRoleSalts replaces the encrypted salts of the role read from storage
+// with their plaintext in place. Roles stored before salts were encrypted are
+// left as they are.
+func decryptRoleSalts(aead cipher.AEAD, roleName string, role *roleEntry) error {
+	if role.EncryptedSalt == "" {
+		return nil
+	}
+
+	var err error
+	role.Salt, err = decryptSalt(aead, roleName, role.EncryptedSalt)
+	if err != nil {
+		return err
+	}
+
+	if len(role.EncryptedPreviousSalts) > 0 {
+		role.PreviousSalts = make(map[int]string, len(role.EncryptedPreviousSalts))
+	}
+	for version, encrypted := range role.EncryptedPreviousSalts {
+		role.PreviousSalts[version], err = decryptSalt(aead, roleName, encrypted)
+		if err != nil {
+			return err
+		}
+	}
+
+	role.EncryptedSalt = ""
+	role.EncryptedPreviousSalts = nil
+	return nil
+}
@@ -0,0 +1,227 @@
+package saltyhash
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSalty_SaltEncryption(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	expectedSealWrapped := []string{"roles/", "hash_stream/", "config", "config/mount_keys", "config/salt_key"}
+	if sealWrapped := b.SpecialPaths().SealWrapStorage; strings.Join(sealWrapped, ",") != strings.Join(expectedSealWrapped, ",") {
+		t.Fatalf("expected seal-wrapped storage %v, got %v", expectedSealWrapped, sealWrapped)
+	}
+
+	doRequest := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+		return resp
+	}
+	hashReq := func(roleName string) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "hash/" + roleName + "/sha1",
+			Data:      map[string]interface{}{"input": testSecret},
+		}
+	}
+	rawRole := func(roleName string) []byte {
+		entry, err := storage.Get(context.Background(), "roles/"+roleName)
+		if err != nil || entry == nil {
+			t.Fatalf("missing role entry %s: %v", roleName, err)
+		}
+		return entry.Value
+	}
+
+	doRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data: map[string]interface{}{
+			"salt": testSalt,
+			"mode": "append",
+		},
+	})
+	doRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName + "/rotate",
+	})
+
+	// Test neither the current nor the previous salts are stored in plaintext
+	role, err := b.getRole(context.Background(), storage, testRoleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, salt := range []string{role.Salt, role.PreviousSalts[1]} {
+		if bytes.Contains(rawRole(testRoleName), []byte(salt)) {
+			t.Fatalf("salt stored in plaintext: %s", rawRole(testRoleName))
+		}
+	}
+	if role.PreviousSalts[1] != testSalt {
+		t.Fatalf("bad decrypted previous salt: %q", role.PreviousSalts[1])
+	}
+
+	// Test plaintext roles are readable and encrypted on initialization
+	err = storage.Put(context.Background(), &logical.StorageEntry{
+		Key:   "roles/legacy",
+		Value: []byte(`{"salt":"` + testSalt + `","mode":"append"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := doRequest(hashReq("legacy")).Data["sum"]
+
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if raw := rawRole("legacy"); strings.Contains(string(raw), testSalt) {
+		t.Fatalf("legacy salt not encrypted on initialization: %s", raw)
+	}
+	if sum := doRequest(hashReq("legacy")).Data["sum"]; sum != expected {
+		t.Fatalf("mismatched hashes after encryption: %s != %s", sum, expected)
+	}
+
+	// Test encrypted salts are bound to their role
+	err = storage.Put(context.Background(), &logical.StorageEntry{
+		Key:   "roles/swapped",
+		Value: rawRole("legacy"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.getRole(context.Background(), storage, "swapped"); err == nil {
+		t.Fatal("expected error reading salt encrypted for another role")
+	}
+}
+
+func TestSalty_SaltKeyTransitWrapping(t *testing.T) {
+	// Fake transit mount, "encrypting" by inverting the bytes of the
+	// plaintext, as long as the token isn't revoked.
+	var revoked int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		invert := func(s string) string {
+			decoded, _ := base64.StdEncoding.DecodeString(s)
+			for i := range decoded {
+				decoded[i] ^= 0xff
+			}
+			return base64.StdEncoding.EncodeToString(decoded)
+		}
+
+		var data map[string]interface{}
+		switch {
+		case atomic.LoadInt32(&revoked) == 1 || r.Header.Get("X-Vault-Token") != "transit-token":
+		case r.URL.Path == "/v1/transit/encrypt/salt-key":
+			data = map[string]interface{}{"ciphertext": "vault:v1:" + invert(body["plaintext"])}
+		case r.URL.Path == "/v1/transit/decrypt/salt-key" && strings.HasPrefix(body["ciphertext"], "vault:v1:"):
+			data = map[string]interface{}{"plaintext": invert(strings.TrimPrefix(body["ciphertext"], "vault:v1:"))}
+		}
+		if data == nil {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	b, storage := createBackendWithStorage(t)
+	newBackend := func() *backend {
+		config := logical.TestBackendConfig()
+		config.StorageView = storage
+		b := Backend(context.Background(), config)
+		if err := b.Setup(context.Background(), config); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	hashReq := func() *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "hash/" + testRoleName + "/sha1",
+			Data:      map[string]interface{}{"input": testSecret},
+		}
+	}
+	configReq := func(data map[string]interface{}) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data:      data,
+		}
+	}
+	storedKey := func() saltKeyEntry {
+		entry, err := storage.Get(context.Background(), saltKeyStoragePath)
+		if err != nil || entry == nil {
+			t.Fatalf("missing salt key entry: %v", err)
+		}
+		var key saltKeyEntry
+		if err := entry.DecodeJSON(&key); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	handleRequest(t, b, storage, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRoleName,
+		Data:      map[string]interface{}{"salt": testSalt, "mode": "append"},
+	}, false)
+	expected := handleRequest(t, b, storage, hashReq(), false).Data["sum"]
+	plaintextKey := storedKey().Key
+	if len(plaintextKey) != saltKeyLength {
+		t.Fatalf("bad plaintext salt key: %v", plaintextKey)
+	}
+
+	// Test the transit key requires a transit mount that can wrap the key,
+	// leaving the config and the key unchanged otherwise
+	handleRequest(t, b, storage, configReq(map[string]interface{}{"salt_key_transit_key": "salt-key"}), true)
+	handleRequest(t, b, storage, configReq(map[string]interface{}{
+		"transit_address":      server.URL,
+		"transit_token":        "transit-token",
+		"salt_key_transit_key": "../salt-key",
+	}), true)
+	atomic.StoreInt32(&revoked, 1)
+	handleRequest(t, b, storage, configReq(map[string]interface{}{
+		"transit_address":      server.URL,
+		"transit_token":        "transit-token",
+		"salt_key_transit_key": "salt-key",
+	}), true)
+	if config := handleRequest(t, b, storage, &logical.Request{Operation: logical.ReadOperation, Path: "config"}, false); config.Data["salt_key_transit_key"] != "" {
+		t.Fatalf("config stored despite the failure to wrap the salt key: %v", config.Data)
+	}
+	if key := storedKey(); !bytes.Equal(key.Key, plaintextKey) || key.WrappedKey != "" {
+		t.Fatalf("salt key changed despite the failure to wrap it: %#v", key)
+	}
+
+	// Test the key is wrapped and never stored in plaintext once configured
+	atomic.StoreInt32(&revoked, 0)
+	handleRequest(t, b, storage, configReq(map[string]interface{}{
+		"transit_address":      server.URL,
+		"transit_token":        "transit-token",
+		"salt_key_transit_key": "salt-key",
+	}), false)
+	if key := storedKey(); key.Key != nil || key.TransitKey != "salt-key" || !strings.HasPrefix(key.WrappedKey, "vault:v1:") {
+		t.Fatalf("salt key not wrapped: %#v", key)
+	}
+	handleRequest(t, b, storage, configReq(map[string]interface{}{"salt_key_transit_key": ""}), true)
+
+	// Test the wrapped key is unwrapped through transit by new backends,
+	// which can't read the salts once the token is revoked
+	if sum := handleRequest(t, newBackend(), storage, hashReq(), false).Data["sum"]; sum != expected {
+		t.Fatalf("mismatched hashes with the wrapped salt key: %s != %s", sum, expected)
+	}
+	atomic.StoreInt32(&revoked, 1)
+	handleRequest(t, newBackend(), storage, hashReq(), true)
+}
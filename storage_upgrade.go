@@ -0,0 +1,213 @@
+package saltyhash
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	storageVersionPath = "config/storage_version"
+
+	// Schema versions of the role and config entries written by this version
+	// of the backend. Entries without a schema version predate versioning.
+	//
+	// Role schema versions:
+	//   0: plaintext salts, salt_version possibly unset
+	//   1: salts encrypted with the salt key, salt_version set
+	roleSchemaVersion   = 1
+	configSchemaVersion = 1
+)
+
+// storageVersionEntry records the last storage upgrade applied to the mount.
+type storageVersionEntry struct {
+	Version    int       `json:"version"`
+	UpgradedAt time.Time `json:"upgraded_at"`
+}
+
+// storageUpgrade is a step of the storage upgrade pipeline, bringing the
+// storage of the mount to the given version. Steps must be idempotent, a step
+// interrupted before its version is recorded is run again in full.
+type storageUpgrade struct {
+	version     int
+	description string
+	upgrade     func(b *backend, ctx context.Context, s logical.Storage) error
+}
+
+// storageUpgrades are the storage upgrade steps in version order. Steps are
+// only ever appended.
+var storageUpgrades = []storageUpgrade{
+	{
+		version:     1,
+		description: "rewrite roles with their schema version and encrypted salts",
+		upgrade:     (*backend).upgradeRoles,
+	},
+	{
+		version:     2,
+		description: "rewrite the config with its schema version",
+		upgrade:     (*backend).upgradeConfig,
+	},
+}
+
+// upgradeStorage runs the upgrade steps newer than the recorded storage
+// version, recording the version after each step.
+func (b *backend) upgradeStorage(ctx context.Context, s logical.Storage) error {
+	current, err := getStorageVersion(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	latest := storageUpgrades[len(storageUpgrades)-1].version
+	if current > latest {
+		b.Logger().Warn("storage written by a newer version of the plugin, skipping upgrades",
+			"storage_version", current, "supported_version", latest)
+		return nil
+	}
+
+	for _, step := range storageUpgrades {
+		if step.version <= current {
+			continue
+		}
+
+		b.Logger().Info("upgrading storage", "version", step.version, "description", step.description)
+		if err := step.upgrade(b, ctx, s); err != nil {
+			return fmt.Errorf("failed to upgrade storage to version %d: %w", step.version, err)
+		}
+
+		entry, err := logical.StorageEntryJSON(storageVersionPath, &storageVersionEntry{
+			Version:    step.version,
+			UpgradedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getStorageVersion(ctx context.Context, s logical.Storage) (int, error) {
+	entry, err := s.Get(ctx, storageVersionPath)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	var version storageVersionEntry
+	if err := entry.DecodeJSON(&version); err != nil {
+		return 0, err
+	}
+
+	return version.Version, nil
+}
+
+// upgradeRoleEntry brings a role decoded from storage to the current schema
+// in memory. Salts are decrypted separately, see decryptRoleSalts.
+func upgradeRoleEntry(role *roleEntry) error {
+	if role.SchemaVersion > roleSchemaVersion {
+		return fmt.Errorf("role schema version %d is newer than the supported version %d", role.SchemaVersion, roleSchemaVersion)
+	}
+
+	// Roles stored before salts were versioned hold the first version.
+	if role.SaltVersion == 0 {
+		role.SaltVersion = 1
+	}
+
+	role.SchemaVersion = roleSchemaVersion
+	return nil
+}
+
+// upgradeRoles rewrites the roles stored with an older schema.
+func (b *backend) upgradeRoles(ctx context.Context, s logical.Storage) error {
+	roleNames, err := s.List(ctx, "roles/")
+	if err != nil {
+		return err
+	}
+
+	upgraded := 0
+	for _, roleName := range roleNames {
+		ok, err := b.upgradeRole(ctx, s, roleName)
+		if err != nil {
+			return err
+		}
+		if ok {
+			upgraded++
+		}
+	}
+
+	if upgraded > 0 {
+		b.Logger().Info("upgraded roles", "roles", upgraded, "schema_version", roleSchemaVersion)
+	}
+	return nil
+}
+
+func (b *backend) upgradeRole(ctx context.Context, s logical.Storage, roleName string) (bool, error) {
+	lock := b.roleLock(roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := s.Get(ctx, "roles/"+roleName)
+	if err != nil || entry == nil {
+		return false, err
+	}
+
+	var stored roleEntry
+	if err := entry.DecodeJSON(&stored); err != nil {
+		return false, err
+	}
+	if stored.SchemaVersion >= roleSchemaVersion {
+		return false, nil
+	}
+
+	role, err := b.getRole(ctx, s, roleName)
+	if err != nil {
+		return false, err
+	}
+
+	return true, b.putRole(ctx, s, roleName, role)
+}
+
+// upgradeConfigEntry brings a config decoded from storage to the current
+// schema in memory.
+func upgradeConfigEntry(config *configEntry) error {
+	if config.SchemaVersion > configSchemaVersion {
+		return fmt.Errorf("config schema version %d is newer than the supported version %d", config.SchemaVersion, configSchemaVersion)
+	}
+
+	config.SchemaVersion = configSchemaVersion
+	return nil
+}
+
+// upgradeConfig rewrites the config if stored with an older schema.
+func (b *backend) upgradeConfig(ctx context.Context, s logical.Storage) error {
+	entry, err := s.Get(ctx, configStoragePath)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	var config configEntry
+	if err := entry.DecodeJSON(&config); err != nil {
+		return err
+	}
+	if config.SchemaVersion >= configSchemaVersion {
+		return nil
+	}
+	if err := upgradeConfigEntry(&config); err != nil {
+		return err
+	}
+
+	jsonEntry, err := logical.StorageEntryJSON(configStoragePath, &config)
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, jsonEntry); err != nil {
+		return err
+	}
+
+	b.invalidateConfig()
+	return nil
+}
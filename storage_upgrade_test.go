@@ -0,0 +1,118 @@
+package saltyhash
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSalty_StorageUpgrade(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	putRaw := func(key, value string) {
+		if err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	getRaw := func(key string) []byte {
+		entry, err := storage.Get(ctx, key)
+		if err != nil || entry == nil {
+			t.Fatalf("missing entry %s: %v", key, err)
+		}
+		return entry.Value
+	}
+	decodeRaw := func(key string, out interface{}) {
+		if err := json.Unmarshal(getRaw(key), out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(roleName string) interface{} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "hash/" + roleName + "/sha2-256",
+			Data:      map[string]interface{}{"input": testSecret},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v, err: %v", resp, err)
+		}
+		return resp.Data["sum"]
+	}
+	initialize := func() {
+		if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Entries as written before storage versioning
+	putRaw("roles/"+testRoleName, `{"salt":"`+testSalt+`","mode":"append"}`)
+	putRaw(configStoragePath, `{"debug":true}`)
+
+	expected := hash(testRoleName)
+
+	// Test the upgrade rewrites every entry with its schema version
+	initialize()
+
+	var role map[string]interface{}
+	decodeRaw("roles/"+testRoleName, &role)
+	if role["schema_version"] != float64(roleSchemaVersion) || role["salt_version"] != float64(1) {
+		t.Fatalf("role not upgraded: %v", role)
+	}
+	if _, ok := role["salt"]; ok || role["encrypted_salt"] == "" {
+		t.Fatalf("role salt not encrypted: %v", role)
+	}
+
+	var config map[string]interface{}
+	decodeRaw(configStoragePath, &config)
+	if config["schema_version"] != float64(configSchemaVersion) || config["debug"] != true {
+		t.Fatalf("config not upgraded: %v", config)
+	}
+
+	version, err := getStorageVersion(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != storageUpgrades[len(storageUpgrades)-1].version {
+		t.Fatalf("storage version not recorded: %d", version)
+	}
+
+	if sum := hash(testRoleName); sum != expected {
+		t.Fatalf("mismatched hashes after upgrade: %s != %s", sum, expected)
+	}
+
+	// Test upgrades are idempotent, upgraded entries aren't rewritten even if
+	// the steps run again
+	upgradedRole := getRaw("roles/" + testRoleName)
+	upgradedConfig := getRaw(configStoragePath)
+
+	initialize()
+	if err := storage.Delete(ctx, storageVersionPath); err != nil {
+		t.Fatal(err)
+	}
+	initialize()
+
+	if !bytes.Equal(getRaw("roles/"+testRoleName), upgradedRole) || !bytes.Equal(getRaw(configStoragePath), upgradedConfig) {
+		t.Fatal("upgraded entries rewritten")
+	}
+
+	// Test entries written by a newer version are refused rather than misread
+	putRaw("roles/future", `{"schema_version":99,"mode":"append"}`)
+	if _, err := b.getRole(ctx, storage, "future"); err == nil {
+		t.Fatal("expected error reading a role of a newer schema")
+	}
+
+	// Test a storage version newer than supported skips the upgrades
+	putRaw(storageVersionPath, `{"version":99}`)
+	putRaw("roles/legacy", `{"salt":"`+testSalt+`","mode":"append"}`)
+	initialize()
+
+	var legacy map[string]interface{}
+	decodeRaw("roles/legacy", &legacy)
+	if _, ok := legacy["schema_version"]; ok {
+		t.Fatalf("role upgraded despite newer storage version: %v", legacy)
+	}
+}
@@ -0,0 +1,74 @@
+package saltyhash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/unflag/vault-plugin-secrets-saltyhash/hasher"
+)
+
+// TestSalty_Vectors checks the hash endpoints against the known-answer
+// vectors of the hasher package, so sums stay the same across releases.
+func TestSalty_Vectors(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	f, err := os.Open("hasher/testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	vectors, err := hasher.ReadVectors(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, vector := range vectors.Vectors {
+		role := fmt.Sprintf("vector-%d", i)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.CreateOperation,
+			Path:      "roles/" + role,
+			Data: map[string]interface{}{
+				"salt": vector.Salt,
+				"mode": vector.Mode,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s: unable to create role: %v, resp: %#v", vector.Name, err, resp)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "hash/" + role + "/" + vector.Algorithm,
+			Data: map[string]interface{}{
+				"input": vector.Input,
+			},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("%s: unable to hash: %v, resp: %#v", vector.Name, err, resp)
+		}
+		if resp.Data["sum"] != vector.Sum {
+			t.Errorf("%s: sum %s, expected %s", vector.Name, resp.Data["sum"], vector.Sum)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Storage:   storage,
+			Operation: logical.UpdateOperation,
+			Path:      "hash_batch/" + role + "/" + vector.Algorithm,
+			Data: map[string]interface{}{
+				"input": []interface{}{vector.Input, vector.Input},
+			},
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("%s: unable to hash batch: %v, resp: %#v", vector.Name, err, resp)
+		}
+		if sums := resp.Data["sums"].([]string); len(sums) != 2 || sums[0] != vector.Sum || sums[1] != vector.Sum {
+			t.Errorf("%s: batch sums %v, expected %s", vector.Name, sums, vector.Sum)
+		}
+	}
+}